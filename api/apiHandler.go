@@ -35,11 +35,45 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 	loginUser := GetLoginUser(c)
 	action := c.Param("postAction")
 
+	if action != "login" && !a.ApiService.checkPermission(c, loginUser, action) {
+		return
+	}
+
 	switch action {
 	case "login":
 		a.ApiService.Login(c)
 	case "changePass":
 		a.ApiService.ChangePass(c)
+	case "totpSetup":
+		a.ApiService.TotpSetup(c)
+	case "totpEnable":
+		a.ApiService.TotpEnable(c)
+	case "totpDisable":
+		a.ApiService.TotpDisable(c)
+	case "totpRecoveryCodes":
+		a.ApiService.TotpRecoveryCodes(c)
+	case "addUser":
+		a.ApiService.AddUser(c)
+	case "updateUser":
+		a.ApiService.UpdateUser(c)
+	case "deleteUser":
+		a.ApiService.DeleteUser(c)
+	case "setResellerQuota":
+		a.ApiService.SetResellerQuota(c)
+	case "unban":
+		a.ApiService.Unban(c)
+	case "revokeSession":
+		a.ApiService.RevokeSession(c)
+	case "revokeSessions":
+		a.ApiService.RevokeSessions(c)
+	case "addWebhook":
+		a.ApiService.AddWebhook(c)
+	case "updateWebhook":
+		a.ApiService.UpdateWebhook(c)
+	case "deleteWebhook":
+		a.ApiService.DeleteWebhook(c)
+	case "redeliverWebhook":
+		a.ApiService.RedeliverWebhook(c)
 	case "save":
 		a.ApiService.Save(c, loginUser)
 	case "restartApp":
@@ -52,6 +86,12 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 		a.ApiService.ReassignInboundUsers(c, loginUser)
 	case "linkConvert":
 		a.ApiService.LinkConvert(c)
+	case "importPanel":
+		a.ApiService.ImportPanel(c, loginUser)
+	case "closeConnections":
+		a.ApiService.CloseConnections(c, loginUser)
+	case "importClients":
+		a.ApiService.ImportClients(c, loginUser)
 	case "batchImport":
 		a.ApiService.BatchImport(c, loginUser)
 	case "testNode":
@@ -92,6 +132,10 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 func (a *APIHandler) getHandler(c *gin.Context) {
 	action := c.Param("getAction")
 
+	if action != "logout" && !a.ApiService.checkPermission(c, GetLoginUser(c), action) {
+		return
+	}
+
 	switch action {
 	case "logout":
 		a.ApiService.Logout(c)
@@ -109,6 +153,8 @@ func (a *APIHandler) getHandler(c *gin.Context) {
 		a.ApiService.GetSettings(c)
 	case "stats":
 		a.ApiService.GetStats(c)
+	case "traffic":
+		a.ApiService.GetTraffic(c)
 	case "status":
 		a.ApiService.GetStatus(c)
 	case "subscriptionNodes":
@@ -119,10 +165,28 @@ func (a *APIHandler) getHandler(c *gin.Context) {
 		a.ApiService.GetLogs(c)
 	case "changes":
 		a.ApiService.CheckChanges(c)
+	case "changeLog":
+		a.ApiService.GetChangeLog(c)
+	case "usageHistory":
+		a.ApiService.GetUsageHistory(c)
+	case "exportClients":
+		a.ApiService.ExportClients(c)
+	case "connections":
+		a.ApiService.GetConnections(c)
+	case "warnings":
+		a.ApiService.GetWarnings(c)
 	case "keypairs":
 		a.ApiService.GetKeypairs(c)
 	case "getdb":
 		a.ApiService.GetDb(c)
+	case "bans":
+		a.ApiService.GetBans(c)
+	case "sessions":
+		a.ApiService.GetSessions(c)
+	case "webhooks":
+		a.ApiService.GetWebhooks(c)
+	case "webhookDeliveries":
+		a.ApiService.GetWebhookDeliveries(c)
 	case "tokens":
 		a.ApiService.GetTokens(c)
 	case "singbox-config":
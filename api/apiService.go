@@ -3,6 +3,8 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -32,6 +34,11 @@ type ApiService struct {
 	service.ServerService
 	service.NodeTestService
 	service.SubscriptionService
+	service.WebhookService
+	service.SessionService
+	service.WarningService
+	service.ConnectionService
+	service.MetricsService
 }
 
 func (a *ApiService) LoadData(c *gin.Context) {
@@ -50,6 +57,13 @@ func (a *ApiService) getData(c *gin.Context) (interface{}, error) {
 	if err != nil {
 		return "", err
 	}
+	reseller, owned, err := a.getResellerScope(c)
+	if err != nil {
+		return "", err
+	}
+	if reseller != nil {
+		return a.getResellerData(c, reseller, owned, isUpdated)
+	}
 	onlines, err := a.StatsService.GetOnlines()
 
 	sysInfo := a.ServerService.GetSingboxInfo()
@@ -117,9 +131,50 @@ func (a *ApiService) getData(c *gin.Context) (interface{}, error) {
 	return data, nil
 }
 
+// Resellers only see their own clients, the inbounds to assign them and their onlines
+func (a *ApiService) getResellerData(c *gin.Context, reseller *model.User, owned map[string]bool, isUpdated bool) (interface{}, error) {
+	data := make(map[string]interface{}, 0)
+	onlines, err := a.StatsService.GetOnlines()
+	if err != nil {
+		return "", err
+	}
+	data["onlines"] = map[string]interface{}{
+		"user": filterNames(onlines.User, owned),
+	}
+	if !isUpdated {
+		return data, nil
+	}
+	clients, err := a.ClientService.GetAll()
+	if err != nil {
+		return "", err
+	}
+	inbounds, err := a.InboundService.GetAll()
+	if err != nil {
+		return "", err
+	}
+	filterInboundUsers(inbounds, owned)
+	subURI, err := a.SettingService.GetFinalSubURI(getHostname(c))
+	if err != nil {
+		return "", err
+	}
+	trafficAge, err := a.SettingService.GetTrafficAge()
+	if err != nil {
+		return "", err
+	}
+	data["clients"] = filterClients(clients, reseller.Id)
+	data["inbounds"] = inbounds
+	data["subURI"] = subURI
+	data["enableTraffic"] = trafficAge > 0
+	return data, nil
+}
+
 func (a *ApiService) LoadPartialData(c *gin.Context, objs []string) error {
 	data := make(map[string]interface{}, 0)
 	id := c.Query("id")
+	reseller, owned, err := a.getResellerScope(c)
+	if err != nil {
+		return err
+	}
 
 	for _, obj := range objs {
 		switch obj {
@@ -128,6 +183,9 @@ func (a *ApiService) LoadPartialData(c *gin.Context, objs []string) error {
 			if err != nil {
 				return err
 			}
+			if reseller != nil {
+				filterInboundUsers(inbounds, owned)
+			}
 			data[obj] = inbounds
 		case "outbounds":
 			outbounds, err := a.OutboundService.GetAll()
@@ -158,6 +216,9 @@ func (a *ApiService) LoadPartialData(c *gin.Context, objs []string) error {
 			if err != nil {
 				return err
 			}
+			if reseller != nil {
+				clients = filterClients(clients, reseller.Id)
+			}
 			data[obj] = clients
 		case "config":
 			config, err := a.SettingService.GetConfig()
@@ -187,6 +248,150 @@ func (a *ApiService) GetUsers(c *gin.Context) {
 	jsonObj(c, *users, nil)
 }
 
+func (a *ApiService) AddUser(c *gin.Context) {
+	username := c.Request.FormValue("username")
+	password := c.Request.FormValue("password")
+	role := c.Request.FormValue("role")
+	err := a.UserService.AddUser(username, password, role)
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) UpdateUser(c *gin.Context) {
+	id := c.Request.FormValue("id")
+	role := c.Request.FormValue("role")
+	password := c.Request.FormValue("password")
+	err := a.UserService.UpdateUser(id, role, password)
+	if err == nil && password != "" {
+		userId, _ := strconv.ParseUint(id, 10, 32)
+		err = a.SessionService.RevokeUser(uint(userId), 0)
+	}
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) SetResellerQuota(c *gin.Context) {
+	id := c.Request.FormValue("id")
+	maxClients, err := strconv.Atoi(c.Request.FormValue("maxClients"))
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	maxVolume, err := strconv.ParseInt(c.Request.FormValue("maxVolume"), 10, 64)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	maxExpiryDays, err := strconv.Atoi(c.Request.FormValue("maxExpiryDays"))
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	err = a.UserService.SetResellerQuota(id, maxClients, maxVolume, maxExpiryDays)
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) GetBans(c *gin.Context) {
+	bans, err := a.LimiterService.GetBans()
+	jsonObj(c, bans, err)
+}
+
+func (a *ApiService) Unban(c *gin.Context) {
+	id, err := strconv.Atoi(c.Request.FormValue("id"))
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	err = a.LimiterService.Unban(uint(id))
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) GetWebhooks(c *gin.Context) {
+	hooks, err := a.WebhookService.GetWebhooks()
+	jsonObj(c, hooks, err)
+}
+
+func (a *ApiService) AddWebhook(c *gin.Context) {
+	url := c.Request.FormValue("url")
+	events := splitList(c.Request.FormValue("events"))
+	desc := c.Request.FormValue("desc")
+	secret, err := a.WebhookService.AddWebhook(url, events, desc)
+	jsonObj(c, secret, err)
+}
+
+func (a *ApiService) UpdateWebhook(c *gin.Context) {
+	id := c.Request.FormValue("id")
+	url := c.Request.FormValue("url")
+	events := splitList(c.Request.FormValue("events"))
+	desc := c.Request.FormValue("desc")
+	enabled := c.Request.FormValue("enabled") == "true"
+	err := a.WebhookService.UpdateWebhook(id, url, events, desc, enabled)
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) DeleteWebhook(c *gin.Context) {
+	err := a.WebhookService.DeleteWebhook(c.Request.FormValue("id"))
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) GetWebhookDeliveries(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		limit = 100
+	}
+	deliveries, err := a.WebhookService.GetDeliveries(c.Query("id"), limit)
+	jsonObj(c, deliveries, err)
+}
+
+func (a *ApiService) RedeliverWebhook(c *gin.Context) {
+	err := a.WebhookService.Redeliver(c.Request.FormValue("id"))
+	jsonMsg(c, "", err)
+}
+
+// Owners see every session, other admins only their own
+func (a *ApiService) GetSessions(c *gin.Context) {
+	loginUser := GetLoginUser(c)
+	username := loginUser
+	if a.UserService.GetRole(loginUser) == service.RoleOwner {
+		username = ""
+	}
+	sessions, err := a.SessionService.GetSessions(username)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	currentId := GetSessionId(c)
+	for index := range sessions {
+		sessions[index].Current = sessions[index].Id == currentId
+	}
+	jsonObj(c, sessions, nil)
+}
+
+func (a *ApiService) RevokeSession(c *gin.Context) {
+	loginUser := GetLoginUser(c)
+	id, err := strconv.ParseUint(c.Request.FormValue("id"), 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	username := loginUser
+	if a.UserService.GetRole(loginUser) == service.RoleOwner {
+		username = ""
+	}
+	err = a.SessionService.Revoke(uint(id), username)
+	jsonMsg(c, "", err)
+}
+
+// Kill all other sessions of the current admin
+func (a *ApiService) RevokeSessions(c *gin.Context) {
+	err := a.SessionService.RevokeOthers(GetSessionId(c))
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) DeleteUser(c *gin.Context) {
+	id := c.Request.FormValue("id")
+	err := a.UserService.DeleteUser(id)
+	jsonMsg(c, "", err)
+}
+
 func (a *ApiService) GetSettings(c *gin.Context) {
 	data, err := a.SettingService.GetAllSetting()
 	if err != nil {
@@ -203,6 +408,15 @@ func (a *ApiService) GetStats(c *gin.Context) {
 	if err != nil {
 		limit = 100
 	}
+	reseller, owned, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	if reseller != nil && ((resource != "user" && resource != "userRoute") || !owned[tag]) {
+		jsonMsg(c, "", common.NewError("permission denied: stats of ", resource, " ", tag))
+		return
+	}
 	data, err := a.StatsService.GetStats(resource, tag, limit)
 	if err != nil {
 		jsonMsg(c, "", err)
@@ -211,6 +425,30 @@ func (a *ApiService) GetStats(c *gin.Context) {
 	jsonObj(c, data, err)
 }
 
+// Traffic history in buckets, read from the raw, hourly or daily stats depending on the range
+func (a *ApiService) GetTraffic(c *gin.Context) {
+	resource := c.Query("resource")
+	tag := c.Query("tag")
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil {
+		jsonMsg(c, "", common.NewError("invalid start time: ", c.Query("from")))
+		return
+	}
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	bucket, _ := strconv.ParseInt(c.Query("bucket"), 10, 64)
+	reseller, owned, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	if reseller != nil && ((resource != "user" && resource != "userRoute") || !owned[tag]) {
+		jsonMsg(c, "", common.NewError("permission denied: stats of ", resource, " ", tag))
+		return
+	}
+	data, err := a.StatsService.GetTraffic(resource, tag, from, to, bucket)
+	jsonObj(c, data, err)
+}
+
 func (a *ApiService) GetStatus(c *gin.Context) {
 	request := c.Query("r")
 	result := a.ServerService.GetStatus(request)
@@ -219,7 +457,20 @@ func (a *ApiService) GetStatus(c *gin.Context) {
 
 func (a *ApiService) GetOnlines(c *gin.Context) {
 	onlines, err := a.StatsService.GetOnlines()
-	jsonObj(c, onlines, err)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	reseller, owned, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	if reseller != nil {
+		jsonObj(c, map[string]interface{}{"user": filterNames(onlines.User, owned)}, nil)
+		return
+	}
+	jsonObj(c, onlines, nil)
 }
 
 func (a *ApiService) GetLogs(c *gin.Context) {
@@ -237,6 +488,115 @@ func (a *ApiService) CheckChanges(c *gin.Context) {
 	jsonObj(c, changes, nil)
 }
 
+func (a *ApiService) GetUsageHistory(c *gin.Context) {
+	id := c.Query("id")
+	reseller, _, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	if reseller != nil {
+		clients, err := a.ClientService.Get(id)
+		if err != nil || len(*filterClients(clients, reseller.Id)) == 0 {
+			jsonMsg(c, "", common.NewError("client not found"))
+			return
+		}
+	}
+	history, err := a.ClientService.GetUsageHistory(id)
+	jsonObj(c, history, err)
+}
+
+// Recorded usage and expiry warnings, of one client when id is given
+func (a *ApiService) GetWarnings(c *gin.Context) {
+	id := c.Query("id")
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		limit = 100
+	}
+	reseller, _, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	if reseller != nil {
+		clients, err := a.ClientService.Get(id)
+		if id == "" || err != nil || len(*filterClients(clients, reseller.Id)) == 0 {
+			jsonMsg(c, "", common.NewError("client not found"))
+			return
+		}
+	}
+	warnings, err := a.WarningService.GetWarnings(id, limit)
+	jsonObj(c, warnings, err)
+}
+
+func (a *ApiService) GetConnections(c *gin.Context) {
+	_, owned, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	connections, err := a.ConnectionService.GetConnections(owned)
+	jsonObj(c, connections, err)
+}
+
+// Close a connection by "id", or all connections of a "user" or an "outbound"
+func (a *ApiService) CloseConnections(c *gin.Context, loginUser string) {
+	_, owned, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	id := c.Request.FormValue("id")
+	user := c.Request.FormValue("user")
+	outbound := c.Request.FormValue("outbound")
+	closed, err := a.ConnectionService.Close(id, user, outbound, owned)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	err = a.ConfigService.AddChange(loginUser, "connections", "close", map[string]interface{}{
+		"id":       id,
+		"user":     user,
+		"outbound": outbound,
+		"closed":   closed,
+	})
+	jsonObj(c, closed, err)
+}
+
+// Paged change log filtered by actor, key, action, object id and a from/to unix time range
+func (a *ApiService) GetChangeLog(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 50
+	}
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	changes, total, err := a.ConfigService.QueryChanges(service.ChangesFilter{
+		Actor:  c.Query("actor"),
+		Key:    c.Query("key"),
+		Action: c.Query("action"),
+		ObjId:  c.Query("objId"),
+		From:   from,
+		To:     to,
+		Offset: (page - 1) * limit,
+		Limit:  limit,
+	})
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, map[string]interface{}{
+		"items": changes,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	}, nil)
+}
+
 func (a *ApiService) GetKeypairs(c *gin.Context) {
 	kType := c.Query("k")
 	options := c.Query("o")
@@ -256,6 +616,79 @@ func (a *ApiService) GetDb(c *gin.Context) {
 	c.Writer.Write(db)
 }
 
+// CSV of all clients, or of those matching the group, enable and inbound query parameters
+func (a *ApiService) ExportClients(c *gin.Context) {
+	filter := service.ClientsFilter{All: true}
+	if group, ok := c.GetQuery("group"); ok {
+		filter.Group = &group
+	}
+	if value := c.Query("enable"); value != "" {
+		enable := value == "true"
+		filter.Enable = &enable
+	}
+	if inbound, err := strconv.ParseUint(c.Query("inbound"), 10, 64); err == nil {
+		filter.Inbound = uint(inbound)
+	}
+	reseller, _, err := a.getResellerScope(c)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	data, err := a.ClientService.ExportCsv(&filter, reseller)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=clients_"+time.Now().Format("20060102-150405")+".csv")
+	c.Writer.Write(data)
+}
+
+// Import clients from an uploaded "file" or a "csv" form value. With dryRun=true only the validation report is returned.
+func (a *ApiService) ImportClients(c *gin.Context, loginUser string) {
+	var data []byte
+	file, _, err := c.Request.FormFile("file")
+	if err == nil {
+		defer file.Close()
+		data, err = io.ReadAll(io.LimitReader(file, 32<<20))
+		if err != nil {
+			jsonMsg(c, "", err)
+			return
+		}
+	} else {
+		data = []byte(c.Request.FormValue("csv"))
+	}
+	dryRun := c.Request.FormValue("dryRun") == "true"
+	result, err := a.ConfigService.ImportClientsCsv(data, dryRun, loginUser, getHostname(c))
+	jsonObj(c, result, err)
+}
+
+// Import an uploaded x-ui, 3x-ui or Marzban database. With dryRun=true only the report is returned,
+// with includeDisabled=true disabled x-ui inbounds are imported as enabled instead of skipped.
+func (a *ApiService) ImportPanel(c *gin.Context, loginUser string) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	tmp, err := os.CreateTemp("", "s-ui-import-*.db")
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	err = c.SaveUploadedFile(file, tmp.Name())
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	dryRun := c.Request.FormValue("dryRun") == "true"
+	includeDisabled := c.Request.FormValue("includeDisabled") == "true"
+	report, err := a.ConfigService.ImportPanel(tmp.Name(), dryRun, includeDisabled, loginUser, getHostname(c))
+	jsonObj(c, report, err)
+}
+
 func (a *ApiService) postActions(c *gin.Context) (string, json.RawMessage, error) {
 	var data map[string]json.RawMessage
 	err := c.ShouldBind(&data)
@@ -452,7 +885,11 @@ func (a *ApiService) ReassignInboundUsers(c *gin.Context, loginUser string) {
 
 func (a *ApiService) Login(c *gin.Context) {
 	remoteIP := getRemoteIp(c)
-	loginUser, err := a.UserService.Login(c.Request.FormValue("user"), c.Request.FormValue("pass"), remoteIP)
+	loginUser, err := a.UserService.Login(c.Request.FormValue("user"), c.Request.FormValue("pass"), c.Request.FormValue("code"), remoteIP)
+	if err == service.ErrTotpRequired {
+		jsonMsgObj(c, "", map[string]interface{}{"totpRequired": true}, err)
+		return
+	}
 	if err != nil {
 		jsonMsg(c, "", err)
 		return
@@ -480,6 +917,11 @@ func (a *ApiService) ChangePass(c *gin.Context) {
 	newPass := c.Request.FormValue("newPass")
 	err := a.UserService.ChangePass(id, oldPass, newUsername, newPass)
 	if err == nil {
+		userId, _ := strconv.ParseUint(id, 10, 32)
+		err = a.SessionService.RevokeUser(uint(userId), GetSessionId(c))
+		if err != nil {
+			logger.Warning("unable to revoke other sessions: ", err)
+		}
 		logger.Info("change user credentials success")
 		jsonMsg(c, "save", nil)
 	} else {
@@ -488,6 +930,39 @@ func (a *ApiService) ChangePass(c *gin.Context) {
 	}
 }
 
+func (a *ApiService) TotpSetup(c *gin.Context) {
+	loginUser := GetLoginUser(c)
+	result, err := a.UserService.TotpSetup(loginUser)
+	jsonObj(c, result, err)
+}
+
+func (a *ApiService) TotpEnable(c *gin.Context) {
+	loginUser := GetLoginUser(c)
+	code := c.Request.FormValue("code")
+	recoveryCodes, err := a.UserService.TotpEnable(loginUser, code)
+	if err == nil {
+		logger.Info("user ", loginUser, " enabled two-factor authentication")
+	}
+	jsonObj(c, recoveryCodes, err)
+}
+
+func (a *ApiService) TotpDisable(c *gin.Context) {
+	loginUser := GetLoginUser(c)
+	pass := c.Request.FormValue("pass")
+	err := a.UserService.TotpDisable(loginUser, pass)
+	if err == nil {
+		logger.Info("user ", loginUser, " disabled two-factor authentication")
+	}
+	jsonMsg(c, "", err)
+}
+
+func (a *ApiService) TotpRecoveryCodes(c *gin.Context) {
+	loginUser := GetLoginUser(c)
+	code := c.Request.FormValue("code")
+	recoveryCodes, err := a.UserService.TotpRecoveryCodes(loginUser, code)
+	jsonObj(c, recoveryCodes, err)
+}
+
 func (a *ApiService) Save(c *gin.Context, loginUser string) {
 	hostname := getHostname(c)
 	obj := c.Request.FormValue("object")
@@ -808,13 +1283,16 @@ func (a *ApiService) AddToken(c *gin.Context) {
 		return
 	}
 	desc := c.Request.FormValue("desc")
-	token, err := a.UserService.AddToken(loginUser, expiryInt, desc)
+	scopes := splitList(c.Request.FormValue("scopes"))
+	allowedIPs := splitList(c.Request.FormValue("allowedIps"))
+	token, err := a.UserService.AddToken(loginUser, expiryInt, desc, scopes, allowedIPs)
 	jsonObj(c, token, err)
 }
 
 func (a *ApiService) DeleteToken(c *gin.Context) {
+	loginUser := GetLoginUser(c)
 	tokenId := c.Request.FormValue("id")
-	err := a.UserService.DeleteToken(tokenId)
+	err := a.UserService.DeleteToken(tokenId, loginUser)
 	jsonMsg(c, "", err)
 }
 
@@ -914,7 +1392,7 @@ func (a *ApiService) DeleteSubscription(c *gin.Context) {
 		return
 	}
 
-	err = a.SubscriptionService.Delete(uint(id))
+	err = a.ConfigService.DeleteSubscription(uint(id), getCaller(c))
 	if err != nil {
 		jsonMsg(c, "", err)
 		return
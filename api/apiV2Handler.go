@@ -1,7 +1,11 @@
 package api
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/alireza0/s-ui/logger"
@@ -11,13 +15,24 @@ import (
 )
 
 type TokenInMemory struct {
-	Token    string
-	Expiry   int64
-	Username string
+	Id         uint
+	Token      string
+	Expiry     int64
+	Username   string
+	Scopes     []string
+	AllowedIPs []string `json:"allowedIps"`
+	LastUsed   int64
 }
 
+// Context key of the token which authorized the current request
+const tokenUserKey = "API_TOKEN_USER"
+
+// Last-used data is written at most once per interval to avoid a DB write on every request
+const tokenTouchInterval = 60
+
 type APIv2Handler struct {
 	ApiService
+	access sync.Mutex // guards tokens, which requests touch while ReloadTokens replaces them
 	tokens *[]TokenInMemory
 }
 
@@ -40,6 +55,10 @@ func (a *APIv2Handler) postHandler(c *gin.Context) {
 	username := a.findUsername(c)
 	action := c.Param("postAction")
 
+	if !a.ApiService.checkPermission(c, username, action) {
+		return
+	}
+
 	switch action {
 	case "save":
 		a.ApiService.Save(c, username)
@@ -51,6 +70,22 @@ func (a *APIv2Handler) postHandler(c *gin.Context) {
 		a.ApiService.LinkConvert(c)
 	case "importdb":
 		a.ApiService.ImportDb(c)
+	case "importPanel":
+		a.ApiService.ImportPanel(c, username)
+	case "closeConnections":
+		a.ApiService.CloseConnections(c, username)
+	case "importClients":
+		a.ApiService.ImportClients(c, username)
+	case "unban":
+		a.ApiService.Unban(c)
+	case "addWebhook":
+		a.ApiService.AddWebhook(c)
+	case "updateWebhook":
+		a.ApiService.UpdateWebhook(c)
+	case "deleteWebhook":
+		a.ApiService.DeleteWebhook(c)
+	case "redeliverWebhook":
+		a.ApiService.RedeliverWebhook(c)
 	default:
 		jsonMsg(c, "failed", common.NewError("unknown action: ", action))
 	}
@@ -59,6 +94,10 @@ func (a *APIv2Handler) postHandler(c *gin.Context) {
 func (a *APIv2Handler) getHandler(c *gin.Context) {
 	action := c.Param("getAction")
 
+	if !a.ApiService.checkPermission(c, a.findUsername(c), action) {
+		return
+	}
+
 	switch action {
 	case "load":
 		a.ApiService.LoadData(c)
@@ -74,6 +113,8 @@ func (a *APIv2Handler) getHandler(c *gin.Context) {
 		a.ApiService.GetSettings(c)
 	case "stats":
 		a.ApiService.GetStats(c)
+	case "traffic":
+		a.ApiService.GetTraffic(c)
 	case "status":
 		a.ApiService.GetStatus(c)
 	case "onlines":
@@ -82,37 +123,140 @@ func (a *APIv2Handler) getHandler(c *gin.Context) {
 		a.ApiService.GetLogs(c)
 	case "changes":
 		a.ApiService.CheckChanges(c)
+	case "changeLog":
+		a.ApiService.GetChangeLog(c)
+	case "usageHistory":
+		a.ApiService.GetUsageHistory(c)
+	case "exportClients":
+		a.ApiService.ExportClients(c)
+	case "connections":
+		a.ApiService.GetConnections(c)
+	case "warnings":
+		a.ApiService.GetWarnings(c)
 	case "keypairs":
 		a.ApiService.GetKeypairs(c)
 	case "getdb":
 		a.ApiService.GetDb(c)
+	case "bans":
+		a.ApiService.GetBans(c)
+	case "webhooks":
+		a.ApiService.GetWebhooks(c)
+	case "webhookDeliveries":
+		a.ApiService.GetWebhookDeliveries(c)
 	default:
 		jsonMsg(c, "failed", common.NewError("unknown action: ", action))
 	}
 }
 
 func (a *APIv2Handler) findUsername(c *gin.Context) string {
+	return c.GetString(tokenUserKey)
+}
+
+// A copy of the token of the request, it must not be changed through the returned value
+func (a *APIv2Handler) findToken(c *gin.Context) *TokenInMemory {
 	token := c.Request.Header.Get("Token")
-	for index, t := range *a.tokens {
-		if t.Expiry > 0 && t.Expiry < time.Now().Unix() {
-			(*a.tokens) = append((*a.tokens)[:index], (*a.tokens)[index+1:]...)
+	if token == "" {
+		token = strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return nil
+	}
+	hashedToken := common.HashToken(token)
+	now := time.Now().Unix()
+	a.access.Lock()
+	defer a.access.Unlock()
+	if a.tokens == nil {
+		return nil
+	}
+	for index := range *a.tokens {
+		t := (*a.tokens)[index]
+		if t.Expiry > 0 && t.Expiry < now {
 			continue
 		}
-		if t.Token == token {
-			return t.Username
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(hashedToken)) == 1 {
+			return &t
+		}
+	}
+	return nil
+}
+
+// Mark a token as used now. Returns false when it was used within the touch interval.
+func (a *APIv2Handler) touchToken(id uint, now int64) bool {
+	a.access.Lock()
+	defer a.access.Unlock()
+	if a.tokens == nil {
+		return false
+	}
+	for index := range *a.tokens {
+		t := &(*a.tokens)[index]
+		if t.Id == id {
+			if now-t.LastUsed <= tokenTouchInterval {
+				return false
+			}
+			t.LastUsed = now
+			return true
 		}
 	}
-	return ""
+	return false
+}
+
+// A token without scopes may call every action allowed to its owner.
+// Scopes are action names, and save is scoped per object as "save:<object>".
+func (t *TokenInMemory) allows(action string, key string) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range t.Scopes {
+		if scope == key || scope == action {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *APIv2Handler) checkToken(c *gin.Context) {
-	username := a.findUsername(c)
-	if username != "" {
-		c.Next()
+	action := c.Param("postAction")
+	if action == "" {
+		action = c.Param("getAction")
+	}
+	_, err := a.authorizeToken(c, action, permissionKey(c, action))
+	if err != nil {
+		jsonMsg(c, "", err)
+		c.Abort()
 		return
 	}
-	jsonMsg(c, "", common.NewError("invalid token"))
-	c.Abort()
+	c.Next()
+}
+
+// Check the request token against the limiter, its IP allowlist and its scopes.
+// The returned status is the HTTP code a REST caller should answer with.
+func (a *APIv2Handler) authorizeToken(c *gin.Context, action string, key string) (int, error) {
+	remoteIP := getRemoteIp(c)
+	// Tokens are verified without delay, so parallel requests need no reserved attempt
+	err := a.LimiterService.TokenBlocked(remoteIP)
+	if err != nil {
+		return http.StatusTooManyRequests, err
+	}
+	token := a.findToken(c)
+	if token == nil {
+		a.LimiterService.TokenFailed(remoteIP, "invalid api token")
+		return http.StatusUnauthorized, common.NewError("invalid token")
+	}
+	if !common.IPAllowed(remoteIP, token.AllowedIPs) {
+		logger.Warning("api token ", token.Id, " used from not allowed IP: ", remoteIP)
+		return http.StatusForbidden, common.NewError("token is not allowed from ", remoteIP)
+	}
+	if !token.allows(action, key) {
+		return http.StatusForbidden, common.NewError("token scope denied: ", key)
+	}
+	if a.touchToken(token.Id, time.Now().Unix()) {
+		err = a.UserService.TouchToken(token.Id, remoteIP)
+		if err != nil {
+			logger.Warning("unable to update token usage: ", err)
+		}
+	}
+	c.Set(tokenUserKey, token.Username)
+	return http.StatusOK, nil
 }
 
 func (a *APIv2Handler) ReloadTokens() {
@@ -123,7 +267,9 @@ func (a *APIv2Handler) ReloadTokens() {
 		if err != nil {
 			logger.Error("unable to load tokens: ", err)
 		}
+		a.access.Lock()
 		a.tokens = &newTokens
+		a.access.Unlock()
 	} else {
 		logger.Error("unable to load tokens: ", err)
 	}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsHandler serves Prometheus metrics to logged in admins and apiv2 tokens, when enabled in the settings
+type MetricsHandler struct {
+	ApiService
+	apiv2 *APIv2Handler
+}
+
+func NewMetricsHandler(g *gin.RouterGroup, a2 *APIv2Handler) {
+	a := &MetricsHandler{
+		apiv2: a2,
+	}
+	g.GET("", a.metrics)
+}
+
+func (a *MetricsHandler) metrics(c *gin.Context) {
+	enabled, err := a.SettingService.GetMetricsEnable()
+	if err != nil || !enabled {
+		c.String(http.StatusNotFound, "")
+		return
+	}
+	username := GetLoginUser(c)
+	if !IsLogin(c) {
+		status, err := a.apiv2.authorizeToken(c, "metrics", "metrics")
+		if err != nil {
+			c.String(status, err.Error())
+			return
+		}
+		username = a.apiv2.findUsername(c)
+	}
+	if !a.permitted(c, username, "metrics") {
+		c.String(http.StatusForbidden, "permission denied: metrics")
+		return
+	}
+	text, err := a.MetricsService.Metrics()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(text))
+}
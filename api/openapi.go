@@ -0,0 +1,178 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/alireza0/s-ui/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Objects are sing-box options plus the panel fields, so only the common fields are described
+var restSchemas = map[string]map[string]interface{}{
+	"inbounds":      objectSchema([]string{"type", "tag"}, "id", "type", "tag", "tls_id", "listen", "listen_port"),
+	"outbounds":     objectSchema([]string{"type", "tag"}, "id", "type", "tag"),
+	"endpoints":     objectSchema([]string{"type", "tag"}, "id", "type", "tag"),
+	"services":      objectSchema([]string{"type", "tag"}, "id", "type", "tag", "tls_id"),
+	"tls":           objectSchema([]string{"name"}, "id", "name", "server", "client"),
+	"clients":       objectSchema([]string{"name"}, "id", "enable", "name", "desc", "group", "inbounds", "config", "volume", "expiry", "up", "down"),
+	"subscriptions": objectSchema([]string{"name", "url"}, "id", "name", "url", "enabled", "updateInterval", "updateMode", "lastUpdate", "nodeCount"),
+}
+
+func objectSchema(required []string, fields ...string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, field := range fields {
+		switch field {
+		case "id", "tls_id", "listen_port", "volume", "expiry", "up", "down", "updateInterval", "lastUpdate", "nodeCount":
+			properties[field] = map[string]string{"type": "integer"}
+		case "enable", "enabled":
+			properties[field] = map[string]string{"type": "boolean"}
+		case "inbounds":
+			properties[field] = map[string]interface{}{"type": "array", "items": map[string]string{"type": "integer"}}
+		case "server", "client", "config":
+			properties[field] = map[string]string{"type": "object"}
+		default:
+			properties[field] = map[string]string{"type": "string"}
+		}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"required":             required,
+		"properties":           properties,
+		"additionalProperties": true,
+	}
+}
+
+func schemaRef(name string) map[string]string {
+	return map[string]string{"$ref": "#/components/schemas/" + name}
+}
+
+func errorResponses(codes ...string) map[string]interface{} {
+	responses := map[string]interface{}{}
+	for _, code := range codes {
+		responses[code] = map[string]interface{}{"$ref": "#/components/responses/Error"}
+	}
+	return responses
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+func buildOpenAPI(serverURL string) map[string]interface{} {
+	kinds := []string{"subscriptions"}
+	for kind := range restKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"code":  map[string]string{"type": "integer"},
+				"error": map[string]string{"type": "string"},
+			},
+		},
+	}
+	paths := map[string]interface{}{}
+	idParam := map[string]interface{}{
+		"name": "id", "in": "path", "required": true,
+		"schema": map[string]string{"type": "integer"},
+	}
+	for _, kind := range kinds {
+		schemas[kind] = restSchemas[kind]
+		listSchema := map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{"type": "array", "items": schemaRef(kind)},
+				"total": map[string]string{"type": "integer"},
+				"page":  map[string]string{"type": "integer"},
+				"limit": map[string]string{"type": "integer"},
+			},
+		}
+		one := map[string]interface{}{"description": "The " + kind + " object", "content": jsonContent(schemaRef(kind))}
+		body := map[string]interface{}{"required": true, "content": jsonContent(schemaRef(kind))}
+		withErrors := func(ok map[string]interface{}, codes ...string) map[string]interface{} {
+			responses := errorResponses(codes...)
+			for code, response := range ok {
+				responses[code] = response
+			}
+			return responses
+		}
+		paths["/"+kind] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "List " + kind,
+				"tags":    []string{kind},
+				"parameters": []interface{}{
+					map[string]interface{}{"name": "page", "in": "query", "schema": map[string]interface{}{"type": "integer", "minimum": 1, "default": 1}},
+					map[string]interface{}{"name": "limit", "in": "query", "schema": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": restMaxLimit, "default": restDefaultLimit}},
+				},
+				"description": "Any other query parameter filters on the top level field of the same name.",
+				"responses": withErrors(map[string]interface{}{
+					"200": map[string]interface{}{"description": "A page of " + kind, "content": jsonContent(listSchema)},
+				}, "400", "401", "403", "429"),
+			},
+			"post": map[string]interface{}{
+				"summary":     "Create " + strings.TrimSuffix(kind, "s"),
+				"tags":        []string{kind},
+				"requestBody": body,
+				"responses":   withErrors(map[string]interface{}{"201": one}, "400", "401", "403", "429"),
+			},
+		}
+		paths["/"+kind+"/{id}"] = map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"get": map[string]interface{}{
+				"summary":   "Get " + strings.TrimSuffix(kind, "s"),
+				"tags":      []string{kind},
+				"responses": withErrors(map[string]interface{}{"200": one}, "401", "403", "404", "429"),
+			},
+			"put": map[string]interface{}{
+				"summary":     "Replace " + strings.TrimSuffix(kind, "s"),
+				"tags":        []string{kind},
+				"requestBody": body,
+				"responses":   withErrors(map[string]interface{}{"200": one}, "400", "401", "403", "404", "429"),
+			},
+			"patch": map[string]interface{}{
+				"summary":     "Update the given fields of " + strings.TrimSuffix(kind, "s"),
+				"tags":        []string{kind},
+				"requestBody": map[string]interface{}{"required": true, "content": jsonContent(map[string]string{"type": "object"})},
+				"responses":   withErrors(map[string]interface{}{"200": one}, "400", "401", "403", "404", "429"),
+			},
+			"delete": map[string]interface{}{
+				"summary":   "Delete " + strings.TrimSuffix(kind, "s"),
+				"tags":      []string{kind},
+				"responses": withErrors(map[string]interface{}{"204": map[string]string{"description": "Deleted"}}, "400", "401", "403", "404", "429"),
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   config.GetName() + " API",
+			"version": config.GetVersion(),
+		},
+		"servers":  []interface{}{map[string]string{"url": serverURL}},
+		"security": []interface{}{map[string][]string{"token": {}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]string{"type": "apiKey", "in": "header", "name": "Token"},
+			},
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{"description": "Error", "content": jsonContent(schemaRef("Error"))},
+			},
+		},
+	}
+}
+
+func (a *RESTHandler) openAPI(c *gin.Context) {
+	serverURL := strings.TrimSuffix(c.Request.URL.Path, "/openapi.json")
+	c.JSON(http.StatusOK, buildOpenAPI(serverURL))
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/util/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Self-service actions which every logged in admin may call
+var commonActions = []string{
+	"login", "logout", "changePass",
+	"totpSetup", "totpEnable", "totpDisable", "totpRecoveryCodes",
+	"tokens", "addToken", "deleteToken",
+	"sessions", "revokeSession", "revokeSessions",
+}
+
+var readActions = []string{
+	"load", "inbounds", "outbounds", "endpoints", "services", "tls", "clients", "config",
+	"stats", "traffic", "status", "onlines", "subscriptions", "subscriptionNodes", "usageHistory", "warnings", "exportClients", "connections", "metrics",
+}
+
+// Permission matrix of non-owner roles. Owners may call every action.
+// The save action is checked per object as "save:<object>".
+var rolePermissions = map[string][]string{
+	service.RoleOperator: append(append([]string{
+		"save:clients", "importClients", "closeConnections", "changes", "changeLog", "keypairs",
+	}, readActions...), commonActions...),
+	service.RoleReadOnly: append(append([]string{}, readActions...), commonActions...),
+	service.RoleReseller: append([]string{
+		"save:clients", "load", "clients", "inbounds", "stats", "traffic", "onlines", "keypairs", "usageHistory", "warnings",
+		"exportClients", "importClients", "connections", "closeConnections",
+	}, commonActions...),
+}
+
+// Context key of the admin behind the current request, set for both session and token calls
+const callerKey = "API_CALLER"
+
+func getCaller(c *gin.Context) string {
+	return c.GetString(callerKey)
+}
+
+func permissionKey(c *gin.Context, action string) string {
+	if action == "save" {
+		return action + ":" + c.Request.FormValue("object")
+	}
+	return action
+}
+
+func hasPermission(role string, key string) bool {
+	if role == service.RoleOwner {
+		return true
+	}
+	for _, allowed := range rolePermissions[role] {
+		if allowed == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the role of the calling admin against the permission matrix.
+// Denied calls are answered and recorded in the changes log.
+func (a *ApiService) checkPermission(c *gin.Context, username string, action string) bool {
+	key := permissionKey(c, action)
+	if a.permitted(c, username, key) {
+		return true
+	}
+	jsonMsg(c, "", common.NewError("permission denied: ", key))
+	return false
+}
+
+// Same as checkPermission for an already built key, leaving the reply to the caller
+func (a *ApiService) permitted(c *gin.Context, username string, key string) bool {
+	role := a.UserService.GetRole(username)
+	if hasPermission(role, key) {
+		c.Set(callerKey, username)
+		return true
+	}
+	err := a.ConfigService.AddChange(username, "permission", "deny", map[string]string{
+		"action": key,
+		"role":   role,
+		"ip":     getRemoteIp(c),
+	})
+	if err != nil {
+		logger.Warning("unable to record permission denial: ", err)
+	}
+	return false
+}
+
+// Return the calling reseller together with the names of its clients, or nil for other roles
+func (a *ApiService) getResellerScope(c *gin.Context) (*model.User, map[string]bool, error) {
+	reseller, err := a.UserService.GetReseller(getCaller(c))
+	if err != nil || reseller == nil {
+		return nil, nil, err
+	}
+	names, err := a.ClientService.GetOwnedNames(reseller.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	owned := make(map[string]bool, len(names))
+	for _, name := range names {
+		owned[name] = true
+	}
+	return reseller, owned, nil
+}
+
+func filterClients(clients *[]model.Client, ownerId uint) *[]model.Client {
+	result := []model.Client{}
+	for _, client := range *clients {
+		if client.OwnerId == ownerId {
+			result = append(result, client)
+		}
+	}
+	return &result
+}
+
+// Hide other clients' names from the inbound users lists
+func filterInboundUsers(inbounds *[]map[string]interface{}, owned map[string]bool) {
+	if inbounds == nil {
+		return
+	}
+	for _, inbound := range *inbounds {
+		users, ok := inbound["users"].([]string)
+		if !ok {
+			continue
+		}
+		ownedUsers := []string{}
+		for _, user := range users {
+			if owned[user] {
+				ownedUsers = append(ownedUsers, user)
+			}
+		}
+		inbound["users"] = ownedUsers
+	}
+}
+
+func filterNames(names []string, owned map[string]bool) []string {
+	var result []string
+	for _, name := range names {
+		if owned[name] {
+			result = append(result, name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,485 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/util/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Field which identifies each object kind in the legacy save endpoint.
+// Deleting tls and clients takes their id, the other kinds take their tag.
+var restKinds = map[string]string{
+	"inbounds":  "tag",
+	"outbounds": "tag",
+	"endpoints": "tag",
+	"services":  "tag",
+	"tls":       "name",
+	"clients":   "name",
+}
+
+const (
+	restDefaultLimit = 50
+	restMaxLimit     = 1000
+)
+
+type restError struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+type restList struct {
+	Items []map[string]interface{} `json:"items"`
+	Total int                      `json:"total"`
+	Page  int                      `json:"page"`
+	Limit int                      `json:"limit"`
+}
+
+// RESTHandler serves the resource oriented API under api/v1, authenticated by apiv2 tokens
+type RESTHandler struct {
+	ApiService
+	apiv2 *APIv2Handler
+}
+
+func NewRESTHandler(g *gin.RouterGroup, a2 *APIv2Handler) {
+	a := &RESTHandler{
+		apiv2: a2,
+	}
+	a.initRouter(g)
+}
+
+func (a *RESTHandler) initRouter(g *gin.RouterGroup) {
+	g.GET("/openapi.json", a.openAPI)
+	g.GET("/:kind", a.authorize, a.list)
+	g.POST("/:kind", a.authorize, a.create)
+	g.GET("/:kind/:id", a.authorize, a.get)
+	g.PUT("/:kind/:id", a.authorize, a.replace)
+	g.PATCH("/:kind/:id", a.authorize, a.update)
+	g.DELETE("/:kind/:id", a.authorize, a.delete)
+}
+
+func restFail(c *gin.Context, status int, err error) {
+	c.AbortWithStatusJSON(status, restError{Code: status, Error: err.Error()})
+}
+
+// Map database and validation errors of the services to HTTP codes.
+// Services report invalid input with common errors, anything else is a server failure.
+func restFailErr(c *gin.Context, err error) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case database.IsNotFound(err):
+		restFail(c, http.StatusNotFound, err)
+	case common.IsError(err), errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		restFail(c, http.StatusBadRequest, err)
+	default:
+		restFail(c, http.StatusInternalServerError, err)
+	}
+}
+
+// Action and permission key of a request, matching the names used by the action API
+func restAction(method string, kind string) (string, string) {
+	if kind == "subscriptions" {
+		switch method {
+		case http.MethodPost:
+			return "addSubscription", "addSubscription"
+		case http.MethodPut, http.MethodPatch:
+			return "updateSubscription", "updateSubscription"
+		case http.MethodDelete:
+			return "deleteSubscription", "deleteSubscription"
+		}
+		return kind, kind
+	}
+	if method == http.MethodGet {
+		return kind, kind
+	}
+	return "save", "save:" + kind
+}
+
+func (a *RESTHandler) authorize(c *gin.Context) {
+	kind := c.Param("kind")
+	if _, ok := restKinds[kind]; !ok && kind != "subscriptions" {
+		restFail(c, http.StatusNotFound, common.NewError("unknown resource: ", kind))
+		return
+	}
+	action, key := restAction(c.Request.Method, kind)
+	status, err := a.apiv2.authorizeToken(c, action, key)
+	if err != nil {
+		restFail(c, status, err)
+		return
+	}
+	if !a.permitted(c, a.apiv2.findUsername(c), key) {
+		restFail(c, http.StatusForbidden, common.NewError("permission denied: ", key))
+		return
+	}
+	c.Next()
+}
+
+// Convert typed results to the generic shape used for filtering and paging
+func toMaps(v interface{}) ([]map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	err = json.Unmarshal(raw, &result)
+	return result, err
+}
+
+func (a *RESTHandler) loadAll(c *gin.Context, kind string) ([]map[string]interface{}, error) {
+	reseller, owned, err := a.getResellerScope(c)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	switch kind {
+	case "inbounds":
+		inbounds, err := a.InboundService.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		if reseller != nil {
+			filterInboundUsers(inbounds, owned)
+		}
+		data = inbounds
+	case "outbounds":
+		data, err = a.OutboundService.GetAll()
+	case "endpoints":
+		data, err = a.EndpointService.GetAll()
+	case "services":
+		data, err = a.ServicesService.GetAll()
+	case "tls":
+		data, err = a.TlsService.GetAll()
+	case "clients":
+		clients, err := a.ClientService.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		if reseller != nil {
+			clients = filterClients(clients, reseller.Id)
+		}
+		data = clients
+	case "subscriptions":
+		data, err = a.SubscriptionService.GetAll()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toMaps(data)
+}
+
+// Load one object in the full form accepted back by the edit action
+func (a *RESTHandler) loadOne(c *gin.Context, kind string, id string) (map[string]interface{}, error) {
+	if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+		return nil, common.NewError("invalid id: ", id)
+	}
+	var items []map[string]interface{}
+	var err error
+	switch kind {
+	case "inbounds":
+		var inbounds *[]map[string]interface{}
+		inbounds, err = a.InboundService.Get(id)
+		if err == nil {
+			reseller, owned, scopeErr := a.getResellerScope(c)
+			if scopeErr != nil {
+				return nil, scopeErr
+			}
+			if reseller != nil {
+				filterInboundUsers(inbounds, owned)
+			}
+			items, err = toMaps(inbounds)
+		}
+	case "clients":
+		clients, err := a.ClientService.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		reseller, _, err := a.getResellerScope(c)
+		if err != nil {
+			return nil, err
+		}
+		if reseller != nil {
+			clients = filterClients(clients, reseller.Id)
+		}
+		items, err = toMaps(clients)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		items, err = a.loadAll(c, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if fmt.Sprint(item["id"]) == id {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+// Every query parameter except paging is an exact, case insensitive match on a top level field
+func restFilter(c *gin.Context, items []map[string]interface{}) []map[string]interface{} {
+	query := c.Request.URL.Query()
+	result := []map[string]interface{}{}
+	for _, item := range items {
+		match := true
+		for field, values := range query {
+			if field == "page" || field == "limit" {
+				continue
+			}
+			value, ok := item[field]
+			if !ok || !strings.EqualFold(fmt.Sprint(value), values[0]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func (a *RESTHandler) list(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		restFail(c, http.StatusBadRequest, common.NewError("invalid page"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(restDefaultLimit)))
+	if err != nil || limit < 1 || limit > restMaxLimit {
+		restFail(c, http.StatusBadRequest, common.NewErrorf("limit must be between 1 and %d", restMaxLimit))
+		return
+	}
+	items, err := a.loadAll(c, c.Param("kind"))
+	if err != nil {
+		restFail(c, http.StatusInternalServerError, err)
+		return
+	}
+	items = restFilter(c, items)
+	sort.SliceStable(items, func(i, j int) bool {
+		idI, _ := items[i]["id"].(float64)
+		idJ, _ := items[j]["id"].(float64)
+		return idI < idJ
+	})
+	result := restList{Items: []map[string]interface{}{}, Total: len(items), Page: page, Limit: limit}
+	start := (page - 1) * limit
+	if start < len(items) {
+		end := start + limit
+		if end > len(items) {
+			end = len(items)
+		}
+		result.Items = items[start:end]
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *RESTHandler) get(c *gin.Context) {
+	item, err := a.loadOne(c, c.Param("kind"), c.Param("id"))
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	if item == nil {
+		restFail(c, http.StatusNotFound, common.NewError("not found"))
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+func readBody(c *gin.Context) (map[string]interface{}, error) {
+	if !strings.HasPrefix(c.ContentType(), "application/json") {
+		return nil, common.NewError("content type must be application/json")
+	}
+	var body map[string]interface{}
+	err := json.NewDecoder(c.Request.Body).Decode(&body)
+	if err != nil {
+		return nil, common.NewError("invalid json body: ", err)
+	}
+	return body, nil
+}
+
+// Run a change through ConfigService.Save, so it is validated, applied to the core and logged like panel changes
+func (a *RESTHandler) save(c *gin.Context, kind string, act string, data interface{}) error {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = a.ConfigService.Save(kind, act, rawData, c.Query("initUsers"), a.apiv2.findUsername(c), getHostname(c))
+	return err
+}
+
+func (a *RESTHandler) create(c *gin.Context) {
+	kind := c.Param("kind")
+	body, err := readBody(c)
+	if err != nil {
+		restFail(c, http.StatusBadRequest, err)
+		return
+	}
+	if kind == "subscriptions" {
+		a.saveSubscription(c, 0, body)
+		return
+	}
+	identity, _ := body[restKinds[kind]].(string)
+	if identity == "" {
+		restFail(c, http.StatusBadRequest, common.NewError(restKinds[kind], " is required"))
+		return
+	}
+	delete(body, "id")
+	rawData, err := json.Marshal(body)
+	if err != nil {
+		restFail(c, http.StatusBadRequest, err)
+		return
+	}
+	id, err := a.ConfigService.SaveNew(kind, rawData, c.Query("initUsers"), a.apiv2.findUsername(c), getHostname(c))
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	created, err := a.loadOne(c, kind, id)
+	if err != nil || created == nil {
+		c.Status(http.StatusCreated)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (a *RESTHandler) replace(c *gin.Context) {
+	body, err := readBody(c)
+	if err != nil {
+		restFail(c, http.StatusBadRequest, err)
+		return
+	}
+	a.edit(c, body)
+}
+
+// PATCH merges the given top level fields into the stored object
+func (a *RESTHandler) update(c *gin.Context) {
+	body, err := readBody(c)
+	if err != nil {
+		restFail(c, http.StatusBadRequest, err)
+		return
+	}
+	current, err := a.loadOne(c, c.Param("kind"), c.Param("id"))
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	if current == nil {
+		restFail(c, http.StatusNotFound, common.NewError("not found"))
+		return
+	}
+	for field, value := range body {
+		current[field] = value
+	}
+	a.edit(c, current)
+}
+
+func (a *RESTHandler) edit(c *gin.Context, body map[string]interface{}) {
+	kind := c.Param("kind")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	if kind == "subscriptions" {
+		a.saveSubscription(c, uint(id), body)
+		return
+	}
+	current, err := a.loadOne(c, kind, c.Param("id"))
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	if current == nil {
+		restFail(c, http.StatusNotFound, common.NewError("not found"))
+		return
+	}
+	if identity, _ := body[restKinds[kind]].(string); identity == "" {
+		restFail(c, http.StatusBadRequest, common.NewError(restKinds[kind], " is required"))
+		return
+	}
+	body["id"] = id
+	err = a.save(c, kind, "edit", body)
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	a.get(c)
+}
+
+func (a *RESTHandler) delete(c *gin.Context) {
+	kind := c.Param("kind")
+	current, err := a.loadOne(c, kind, c.Param("id"))
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	if current == nil {
+		restFail(c, http.StatusNotFound, common.NewError("not found"))
+		return
+	}
+	switch kind {
+	case "subscriptions":
+		id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+		err = a.ConfigService.DeleteSubscription(uint(id), a.apiv2.findUsername(c))
+	case "tls", "clients":
+		err = a.save(c, kind, "del", current["id"])
+	default:
+		err = a.save(c, kind, "del", current["tag"])
+	}
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (a *RESTHandler) saveSubscription(c *gin.Context, id uint, body map[string]interface{}) {
+	name, _ := body["name"].(string)
+	url, _ := body["url"].(string)
+	if name == "" || url == "" {
+		restFail(c, http.StatusBadRequest, common.NewError("name and url are required"))
+		return
+	}
+	updateMode, _ := body["updateMode"].(string)
+	if updateMode == "" {
+		updateMode = "replace"
+	}
+	interval, _ := body["updateInterval"].(float64)
+	if id == 0 {
+		subscription, err := a.SubscriptionService.Add(name, url, updateMode, int(interval))
+		if err != nil {
+			restFailErr(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, subscription)
+		return
+	}
+	_, err := a.SubscriptionService.GetById(id)
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	enabled, ok := body["enabled"].(bool)
+	if !ok {
+		enabled = true
+	}
+	err = a.SubscriptionService.Update(id, name, url, updateMode, int(interval), enabled)
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	subscription, err := a.SubscriptionService.GetById(id)
+	if err != nil {
+		restFailErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, subscription)
+}
@@ -4,15 +4,22 @@ import (
 	"encoding/gob"
 
 	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
 const (
-	loginUser = "LOGIN_USER"
+	sessionToken = "SESSION_TOKEN"
+	// Per request cache of the session lookup
+	sessionUserKey = "SESSION_USER"
+	sessionIdKey   = "SESSION_ID"
 )
 
+var sessionService service.SessionService
+
 func init() {
 	gob.Register(model.User{})
 }
@@ -26,8 +33,14 @@ func SetLoginUser(c *gin.Context, userName string, maxAge int) error {
 		options.MaxAge = maxAge * 60
 	}
 
+	token, err := sessionService.Create(userName, getRemoteIp(c), c.Request.UserAgent(), maxAge)
+	if err != nil {
+		return err
+	}
+
 	s := sessions.Default(c)
-	s.Set(loginUser, userName)
+	s.Clear()
+	s.Set(sessionToken, token)
 	s.Options(options)
 
 	return s.Save()
@@ -41,17 +54,28 @@ func SetMaxAge(c *gin.Context) error {
 	return s.Save()
 }
 
-func GetLoginUser(c *gin.Context) string {
-	s := sessions.Default(c)
-	obj := s.Get(loginUser)
-	if obj == nil {
-		return ""
+func loadSession(c *gin.Context) {
+	if _, ok := c.Get(sessionUserKey); ok {
+		return
 	}
-	objStr, ok := obj.(string)
-	if !ok {
-		return ""
+	var id uint
+	var username string
+	s := sessions.Default(c)
+	if token, ok := s.Get(sessionToken).(string); ok {
+		id, username = sessionService.Check(token, getRemoteIp(c))
 	}
-	return objStr
+	c.Set(sessionIdKey, id)
+	c.Set(sessionUserKey, username)
+}
+
+func GetLoginUser(c *gin.Context) string {
+	loadSession(c)
+	return c.GetString(sessionUserKey)
+}
+
+func GetSessionId(c *gin.Context) uint {
+	loadSession(c)
+	return c.GetUint(sessionIdKey)
 }
 
 func IsLogin(c *gin.Context) bool {
@@ -59,6 +83,14 @@ func IsLogin(c *gin.Context) bool {
 }
 
 func ClearSession(c *gin.Context) {
+	if id := GetSessionId(c); id > 0 {
+		err := sessionService.Revoke(id, "")
+		if err != nil {
+			logger.Warning("unable to remove session: ", err)
+		}
+	}
+	c.Set(sessionUserKey, "")
+	c.Set(sessionIdKey, uint(0))
 	s := sessions.Default(c)
 	s.Clear()
 	s.Options(sessions.Options{
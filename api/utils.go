@@ -28,6 +28,18 @@ func getRemoteIp(c *gin.Context) string {
 	}
 }
 
+// Split a comma separated form value, dropping empty items
+func splitList(value string) []string {
+	result := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 func getHostname(c *gin.Context) string {
 	host := c.Request.Host
 	if strings.Contains(host, ":") {
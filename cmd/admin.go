@@ -33,6 +33,13 @@ func updateAdmin(username string, password string) {
 
 	if username != "" || password != "" {
 		userService := service.UserService{}
+		if username == "" {
+			// Keep the current username when only a new password is given
+			userModel, err := userService.GetFirstUser()
+			if err == nil {
+				username = userModel.Username
+			}
+		}
 		err := userService.UpdateFirstUser(username, password)
 		if err != nil {
 			fmt.Println("reset admin credentials failed:", err)
@@ -53,12 +60,41 @@ func showAdmin() {
 	if err != nil {
 		fmt.Println("get current user info failed,error info:", err)
 	}
+	if userModel == nil {
+		return
+	}
 	username := userModel.Username
-	userpasswd := userModel.Password
-	if (username == "") || (userpasswd == "") {
-		fmt.Println("current username or password is empty")
+	if username == "" {
+		fmt.Println("current username is empty")
 	}
 	fmt.Println("First admin credentials:")
 	fmt.Println("\tUsername:\t", username)
-	fmt.Println("\tPassword:\t", userpasswd)
+	fmt.Println("\tPassword:\t", "(stored as hash, can not be shown)")
+	fmt.Println("\t2FA:\t\t", userModel.TotpEnabled)
+	fmt.Println("To set a new password use: admin -password <new password>")
+	fmt.Println("To reset credentials to default use: admin -reset")
+}
+
+func disableAdminTotp(username string) {
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	userService := service.UserService{}
+	if username == "" {
+		userModel, err := userService.GetFirstUser()
+		if err != nil {
+			fmt.Println("get current user info failed,error info:", err)
+			return
+		}
+		username = userModel.Username
+	}
+	err = userService.ResetTotp(username)
+	if err != nil {
+		fmt.Println("disable two-factor authentication failed:", err)
+	} else {
+		fmt.Println("two-factor authentication disabled for", username)
+	}
 }
@@ -16,6 +16,7 @@ func ParseCmd() {
 
 	adminCmd := flag.NewFlagSet("admin", flag.ExitOnError)
 	settingCmd := flag.NewFlagSet("setting", flag.ExitOnError)
+	importCmd := flag.NewFlagSet("import", flag.ExitOnError)
 
 	var username string
 	var password string
@@ -25,6 +26,7 @@ func ParseCmd() {
 	var subPath string
 	var reset bool
 	var show bool
+	var disableTotp bool
 	settingCmd.BoolVar(&reset, "reset", false, "reset all settings")
 	settingCmd.BoolVar(&show, "show", false, "show current settings")
 	settingCmd.IntVar(&port, "port", 0, "set panel port")
@@ -32,24 +34,37 @@ func ParseCmd() {
 	settingCmd.IntVar(&subPort, "subPort", 0, "set sub port")
 	settingCmd.StringVar(&subPath, "subPath", "", "set sub path")
 
-	adminCmd.BoolVar(&show, "show", false, "show first admin credentials")
+	adminCmd.BoolVar(&show, "show", false, "show first admin username")
 	adminCmd.BoolVar(&reset, "reset", false, "reset first admin credentials")
 	adminCmd.StringVar(&username, "username", "", "set login username")
 	adminCmd.StringVar(&password, "password", "", "set login password")
+	adminCmd.BoolVar(&disableTotp, "disable2fa", false, "disable two-factor authentication of -username or first admin")
+
+	var importDb string
+	var importHost string
+	var dryRun bool
+	var includeDisabled bool
+	importCmd.StringVar(&importDb, "db", "", "path of the x-ui, 3x-ui or Marzban database")
+	importCmd.StringVar(&importHost, "host", "", "server address of the generated links")
+	importCmd.BoolVar(&dryRun, "dry", false, "only show what would be imported")
+	importCmd.BoolVar(&includeDisabled, "disabled", false, "import disabled x-ui inbounds as enabled instead of skipping them")
 
 	oldUsage := flag.Usage
 	flag.Usage = func() {
 		oldUsage()
 		fmt.Println()
 		fmt.Println("Commands:")
-		fmt.Println("    admin          set/reset/show first admin credentials")
+		fmt.Println("    admin          set/reset first admin credentials or show username")
 		fmt.Println("    uri            Show panel URI")
 		fmt.Println("    migrate        migrate form older version")
+		fmt.Println("    import         import inbounds and clients from x-ui, 3x-ui or Marzban")
 		fmt.Println("    setting        set/reset/show settings")
 		fmt.Println()
 		adminCmd.Usage()
 		fmt.Println()
 		settingCmd.Usage()
+		fmt.Println()
+		importCmd.Usage()
 	}
 
 	flag.Parse()
@@ -79,6 +94,8 @@ func ParseCmd() {
 			showAdmin()
 		case reset:
 			resetAdmin()
+		case disableTotp:
+			disableAdminTotp(username)
 		default:
 			updateAdmin(username, password)
 			showAdmin()
@@ -90,6 +107,14 @@ func ParseCmd() {
 	case "migrate":
 		migration.MigrateDb()
 
+	case "import":
+		err := importCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		importPanel(importDb, importHost, dryRun, includeDisabled)
+
 	case "setting":
 		err := settingCmd.Parse(os.Args[2:])
 		if err != nil {
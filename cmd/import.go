@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/service"
+)
+
+func importPanel(path string, hostname string, dryRun bool, includeDisabled bool) {
+	if path == "" {
+		fmt.Println("database of the panel is required: -db <path>")
+		return
+	}
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if hostname == "" {
+		settingService := service.SettingService{}
+		hostname, _ = settingService.GetSubDomain()
+		if hostname == "" {
+			hostname, _ = settingService.GetWebDomain()
+		}
+		if hostname == "" {
+			hostname = getPublicIP()
+		}
+	}
+
+	configService := service.ConfigService{}
+	report, err := configService.ImportPanel(path, dryRun, includeDisabled, "cli", hostname)
+	if err != nil {
+		fmt.Println("import failed:", err)
+		return
+	}
+	fmt.Println("Source:\t\t", report.Source)
+	fmt.Println("Inbounds:\t", len(report.Inbounds))
+	fmt.Println("Clients:\t", len(report.Clients))
+	fmt.Println("TLS:\t\t", report.Tls)
+	if len(report.Issues) > 0 {
+		fmt.Println()
+		fmt.Println("Not converted:")
+		for _, issue := range report.Issues {
+			if issue.Name != "" {
+				fmt.Printf("\t%s %s: %s\n", issue.Kind, issue.Name, issue.Reason)
+			} else {
+				fmt.Printf("\t%s\n", issue.Reason)
+			}
+		}
+	}
+	fmt.Println()
+	if report.DryRun {
+		fmt.Println("Dry run, nothing was saved")
+	} else {
+		fmt.Println("Import done! Restart s-ui to load the imported inbounds")
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"time"
 
 	"github.com/alireza0/s-ui/logger"
 
@@ -31,6 +32,7 @@ var (
 type Core struct {
 	isRunning bool
 	instance  *Box
+	startTime time.Time
 }
 
 func NewCore() *Core {
@@ -78,6 +80,7 @@ func (c *Core) Start(sbConfig []byte) error {
 	router = service.FromContext[adapter.Router](globalCtx)
 
 	c.isRunning = true
+	c.startTime = time.Now()
 	return nil
 }
 
@@ -92,3 +95,11 @@ func (c *Core) Stop() error {
 func (c *Core) IsRunning() bool {
 	return c.isRunning
 }
+
+// Time since the running box was started
+func (c *Core) Uptime() time.Duration {
+	if !c.isRunning {
+		return 0
+	}
+	return time.Since(c.startTime)
+}
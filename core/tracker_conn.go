@@ -3,94 +3,404 @@ package core
 import (
 	"context"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common/atomic"
+	"github.com/sagernet/sing/common/bufio"
 	"github.com/sagernet/sing/common/network"
 )
 
 type ConnectionInfo struct {
-	ID         string
-	Conn       net.Conn
-	PacketConn network.PacketConn
-	Inbound    string
-	Type       string // "tcp" or "udp"
+	ID          string
+	Conn        net.Conn
+	PacketConn  network.PacketConn
+	Inbound     string
+	Outbound    string
+	Type        string // "tcp" or "udp"
+	User        string
+	Source      string
+	SourcePort  uint16
+	Destination string
+	Start       time.Time
+	Upload      *atomic.Int64
+	Download    *atomic.Int64
+}
+
+// Snapshot of a live connection
+type Connection struct {
+	Id          string `json:"id"`
+	User        string `json:"user"`
+	Inbound     string `json:"inbound"`
+	Outbound    string `json:"outbound"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Network     string `json:"network"`
+	Upload      int64  `json:"upload"`
+	Download    int64  `json:"download"`
+	Start       int64  `json:"start"`
+}
+
+// What happens to a connection from a new address of a client which is at its limit
+const (
+	IPLimitReject = "reject" // the new connection is closed
+	IPLimitDrop   = "drop"   // the connections of the least recently seen address are closed
+)
+
+// Violations are kept until the service layer collects them, one per user and address
+const maxIPViolations = 1000
+
+// Connections of a user from one address over its limit, since the last collection
+type IPViolation struct {
+	DateTime int64    `json:"dateTime"`
+	LastTime int64    `json:"lastTime"`
+	Count    int      `json:"count"`
+	User     string   `json:"user"`
+	Inbound  string   `json:"inbound"`
+	IP       string   `json:"ip"`
+	Action   string   `json:"action"`
+	Dropped  string   `json:"dropped,omitempty"`
+	IPs      []string `json:"ips"`
+	Limit    int      `json:"limit"`
 }
 
 type ConnTracker struct {
 	access      sync.Mutex
 	connections map[string]*ConnectionInfo
+
+	// Open connections of a user by source address
+	userSources map[string]map[string]int
+	// Distinct source addresses of a user and when they were last seen
+	userIPs    map[string]map[string]time.Time
+	ipLimits   map[string]int
+	ipWindow   time.Duration
+	ipMode     string
+	violations []IPViolation
+	violated   map[string]int // index in violations by user and address
 }
 
 func NewConnTracker() *ConnTracker {
 	return &ConnTracker{
 		connections: make(map[string]*ConnectionInfo),
+		userSources: make(map[string]map[string]int),
+		userIPs:     make(map[string]map[string]time.Time),
+		ipLimits:    make(map[string]int),
+		ipWindow:    5 * time.Minute,
+		ipMode:      IPLimitReject,
+		violated:    make(map[string]int),
+	}
+}
+
+// Replace the per-user limits of distinct source addresses within the sliding window
+func (c *ConnTracker) SetIPLimits(limits map[string]int, window time.Duration, mode string) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.ipLimits = limits
+	c.ipWindow = window
+	c.ipMode = mode
+	for user := range c.userIPs {
+		if limits[user] == 0 {
+			delete(c.userIPs, user)
+		}
+	}
+}
+
+// Return and forget the violations recorded since the last call
+func (c *ConnTracker) TakeIPViolations() []IPViolation {
+	c.access.Lock()
+	defer c.access.Unlock()
+	violations := c.violations
+	c.violations = nil
+	c.violated = make(map[string]int)
+	return violations
+}
+
+// Give back violations which could not be saved, so the next collection returns them again
+func (c *ConnTracker) RestoreIPViolations(violations []IPViolation) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	for _, violation := range violations {
+		c.addViolation(violation)
+	}
+}
+
+// Merge a violation into the pending one of the same user and address. Returns false if it was already pending.
+func (c *ConnTracker) addViolation(violation IPViolation) bool {
+	key := violation.User + "|" + violation.IP
+	if index, ok := c.violated[key]; ok {
+		pending := &c.violations[index]
+		pending.Count += violation.Count
+		if violation.DateTime < pending.DateTime {
+			pending.DateTime = violation.DateTime
+		}
+		if violation.LastTime > pending.LastTime {
+			pending.LastTime = violation.LastTime
+			pending.Inbound = violation.Inbound
+			pending.Action = violation.Action
+			pending.Dropped = violation.Dropped
+			pending.IPs = violation.IPs
+			pending.Limit = violation.Limit
+		}
+		return false
 	}
+	if len(c.violations) >= maxIPViolations {
+		return false
+	}
+	c.violated[key] = len(c.violations)
+	c.violations = append(c.violations, violation)
+	return true
+}
+
+// Check a new connection against the limit of its user. Returns false if it must be rejected.
+func (c *ConnTracker) admit(user string, ip string, inbound string) bool {
+	limit := c.ipLimits[user]
+	if user == "" || ip == "" || limit <= 0 {
+		return true
+	}
+	now := time.Now()
+	ips, ok := c.userIPs[user]
+	if !ok {
+		ips = make(map[string]time.Time)
+		c.userIPs[user] = ips
+	}
+	// Addresses with open connections are always in use
+	for addr := range c.userSources[user] {
+		ips[addr] = now
+	}
+	for addr, lastSeen := range ips {
+		if now.Sub(lastSeen) > c.ipWindow {
+			delete(ips, addr)
+		}
+	}
+	if _, known := ips[ip]; known || len(ips) < limit {
+		ips[ip] = now
+		return true
+	}
+
+	violation := IPViolation{
+		DateTime: now.Unix(),
+		LastTime: now.Unix(),
+		Count:    1,
+		User:     user,
+		Inbound:  inbound,
+		IP:       ip,
+		Action:   c.ipMode,
+		Limit:    limit,
+	}
+	for addr := range ips {
+		violation.IPs = append(violation.IPs, addr)
+	}
+	admitted := false
+	if c.ipMode == IPLimitDrop {
+		oldest := ""
+		for addr, lastSeen := range ips {
+			if oldest == "" || lastSeen.Before(ips[oldest]) {
+				oldest = addr
+			}
+		}
+		c.closeConnections(func(connInfo *ConnectionInfo) bool {
+			return connInfo.User == user && connInfo.Source == oldest
+		})
+		delete(ips, oldest)
+		ips[ip] = now
+		violation.Dropped = oldest
+		admitted = true
+	}
+	// Logged once per address until the violations are collected, a reconnecting client would flood the log
+	if c.addViolation(violation) {
+		logger.Warningf("client %s exceeded its limit of %d addresses from %s on %s: %s", user, limit, ip, inbound, c.ipMode)
+	}
+	return admitted
 }
 
 func (c *ConnTracker) generateConnectionID() string {
 	return uuid.Must(uuid.NewV4()).String()
 }
 
-func (c *ConnTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
-	connID := c.generateConnectionID()
-	connInfo := &ConnectionInfo{
-		ID:      connID,
-		Conn:    conn,
-		Inbound: metadata.Inbound,
-		Type:    "tcp",
+func (c *ConnTracker) newConnectionInfo(network string, metadata adapter.InboundContext, matchOutbound adapter.Outbound) *ConnectionInfo {
+	return &ConnectionInfo{
+		ID:          c.generateConnectionID(),
+		Inbound:     metadata.Inbound,
+		Outbound:    matchOutbound.Tag(),
+		Type:        network,
+		User:        metadata.User,
+		Source:      sourceIP(metadata),
+		SourcePort:  metadata.Source.Port,
+		Destination: metadata.Destination.String(),
+		Start:       time.Now(),
+		Upload:      &atomic.Int64{},
+		Download:    &atomic.Int64{},
 	}
+}
+
+func (c *ConnTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
+	connInfo := c.newConnectionInfo("tcp", metadata, matchOutbound)
+	conn = bufio.NewInt64CounterConn(conn, []*atomic.Int64{connInfo.Upload}, []*atomic.Int64{connInfo.Download})
+	connInfo.Conn = conn
 
-	c.trackConnection(connID, connInfo)
+	if !c.trackConnection(connInfo.ID, connInfo) {
+		conn.Close()
+		return conn
+	}
 
-	return c.createWrappedConn(conn, connID)
+	return c.createWrappedConn(conn, connInfo.ID)
 }
 
 func (c *ConnTracker) RoutedPacketConnection(ctx context.Context, conn network.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) network.PacketConn {
-	connID := c.generateConnectionID()
-	connInfo := &ConnectionInfo{
-		ID:         connID,
-		PacketConn: conn,
-		Inbound:    metadata.Inbound,
-		Type:       "udp",
+	connInfo := c.newConnectionInfo("udp", metadata, matchOutbound)
+	conn = bufio.NewInt64CounterPacketConn(conn, []*atomic.Int64{connInfo.Upload}, nil, []*atomic.Int64{connInfo.Download}, nil)
+	connInfo.PacketConn = conn
+
+	if !c.trackConnection(connInfo.ID, connInfo) {
+		conn.Close()
+		return conn
 	}
 
-	c.trackConnection(connID, connInfo)
+	return c.createWrappedPacketConn(conn, connInfo.ID)
+}
 
-	return c.createWrappedPacketConn(conn, connID)
+func sourceIP(metadata adapter.InboundContext) string {
+	if !metadata.Source.Addr.IsValid() {
+		return ""
+	}
+	return metadata.Source.Addr.Unmap().String()
 }
 
 func (c *ConnTracker) CloseConnByInbound(inbound string) int {
 	c.access.Lock()
 	defer c.access.Unlock()
 
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.Inbound == inbound
+	})
+}
+
+// Number of open connections
+func (c *ConnTracker) Count() int {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return len(c.connections)
+}
+
+// Live connections, oldest first
+func (c *ConnTracker) Connections() []Connection {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	connections := make([]Connection, 0, len(c.connections))
+	for _, connInfo := range c.connections {
+		source := connInfo.Source
+		if source != "" {
+			source = net.JoinHostPort(source, strconv.Itoa(int(connInfo.SourcePort)))
+		}
+		connections = append(connections, Connection{
+			Id:          connInfo.ID,
+			User:        connInfo.User,
+			Inbound:     connInfo.Inbound,
+			Outbound:    connInfo.Outbound,
+			Source:      source,
+			Destination: connInfo.Destination,
+			Network:     connInfo.Type,
+			Upload:      connInfo.Upload.Load(),
+			Download:    connInfo.Download.Load(),
+			Start:       connInfo.Start.Unix(),
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].Start < connections[j].Start
+	})
+	return connections
+}
+
+func (c *ConnTracker) CloseConnById(id string) int {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.ID == id
+	})
+}
+
+func (c *ConnTracker) CloseConnByUser(user string) int {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.User == user
+	})
+}
+
+func (c *ConnTracker) CloseConnByOutbound(outbound string) int {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.Outbound == outbound
+	})
+}
+
+func (c *ConnTracker) closeConnections(match func(*ConnectionInfo) bool) int {
 	closedCount := 0
 	for connID, connInfo := range c.connections {
-		if connInfo.Inbound == inbound {
+		if match(connInfo) {
 			if connInfo.Conn != nil {
 				connInfo.Conn.Close()
 			}
 			if connInfo.PacketConn != nil {
 				connInfo.PacketConn.Close()
 			}
-			delete(c.connections, connID)
+			c.forget(connID, connInfo)
 			closedCount++
 		}
 	}
 	return closedCount
 }
 
-func (c *ConnTracker) trackConnection(connID string, connInfo *ConnectionInfo) {
+func (c *ConnTracker) trackConnection(connID string, connInfo *ConnectionInfo) bool {
 	c.access.Lock()
 	defer c.access.Unlock()
+	if !c.admit(connInfo.User, connInfo.Source, connInfo.Inbound) {
+		return false
+	}
 	c.connections[connID] = connInfo
+	if connInfo.User != "" && connInfo.Source != "" {
+		sources, ok := c.userSources[connInfo.User]
+		if !ok {
+			sources = make(map[string]int)
+			c.userSources[connInfo.User] = sources
+		}
+		sources[connInfo.Source]++
+	}
+	return true
 }
 
 func (c *ConnTracker) untrackConnection(connID string) {
 	c.access.Lock()
 	defer c.access.Unlock()
+	if connInfo, ok := c.connections[connID]; ok {
+		c.forget(connID, connInfo)
+	}
+}
+
+// Remove a tracked connection and its reference to the source address of its user
+func (c *ConnTracker) forget(connID string, connInfo *ConnectionInfo) {
 	delete(c.connections, connID)
+	sources, ok := c.userSources[connInfo.User]
+	if !ok {
+		return
+	}
+	if sources[connInfo.Source]--; sources[connInfo.Source] <= 0 {
+		delete(sources, connInfo.Source)
+	}
+	if len(sources) == 0 {
+		delete(c.userSources, connInfo.User)
+	}
 }
 
 func (c *ConnTracker) createWrappedConn(conn net.Conn, connID string) *wrappedConn {
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
+
+	"github.com/op/go-logging"
+)
+
+func TestMain(m *testing.M) {
+	// Violations are logged
+	logger.InitLogger(logging.ERROR)
+	os.Exit(m.Run())
+}
+
+func TestConnTrackerAdmit(t *testing.T) {
+	cases := []struct {
+		mode     string
+		ips      []string
+		want     []bool
+		count    int    // connections over the limit
+		dropped  string // address whose connections were dropped last
+		admitted string // address which is in use after the last connection
+	}{
+		{IPLimitReject, []string{"a", "b", "a", "c", "c"}, []bool{true, true, true, false, false}, 2, "", "a"},
+		{IPLimitDrop, []string{"a", "b", "c"}, []bool{true, true, true}, 1, "a", "c"},
+		{IPLimitReject, []string{"a", "b"}, []bool{true, true}, 0, "", "b"},
+	}
+	for i, c := range cases {
+		tracker := NewConnTracker()
+		tracker.SetIPLimits(map[string]int{"user": 2}, time.Minute, c.mode)
+		for j, ip := range c.ips {
+			if got := tracker.admit("user", ip, "in"); got != c.want[j] {
+				t.Errorf("case %d: connection %d from %s: expected %v, got %v", i, j, ip, c.want[j], got)
+			}
+			// Addresses are seen one second apart, so the first one is the oldest
+			for addr, lastSeen := range tracker.userIPs["user"] {
+				tracker.userIPs["user"][addr] = lastSeen.Add(-time.Second)
+			}
+		}
+		if _, ok := tracker.userIPs["user"][c.admitted]; !ok {
+			t.Errorf("case %d: expected %s in use, got %v", i, c.admitted, tracker.userIPs["user"])
+		}
+		violations := tracker.TakeIPViolations()
+		if c.count == 0 {
+			if len(violations) != 0 {
+				t.Errorf("case %d: expected no violation, got %v", i, violations)
+			}
+			continue
+		}
+		if len(violations) != 1 || violations[0].Count != c.count || violations[0].Dropped != c.dropped {
+			t.Errorf("case %d: expected one violation of %d connections dropping %q, got %v", i, c.count, c.dropped, violations)
+		}
+	}
+}
+
+func TestConnTrackerAdmitWindow(t *testing.T) {
+	tracker := NewConnTracker()
+	tracker.SetIPLimits(map[string]int{"user": 1}, time.Minute, IPLimitReject)
+	if !tracker.admit("", "a", "in") || !tracker.admit("other", "a", "in") {
+		t.Error("expected connections without a limit to be admitted")
+	}
+	if !tracker.admit("user", "a", "in") {
+		t.Fatal("expected the first address to be admitted")
+	}
+	tracker.userIPs["user"]["a"] = time.Now().Add(-2 * time.Minute)
+	if !tracker.admit("user", "b", "in") {
+		t.Error("expected an address to be admitted once the last one left the window")
+	}
+}
+
+func TestConnTrackerRestoreIPViolations(t *testing.T) {
+	tracker := NewConnTracker()
+	tracker.SetIPLimits(map[string]int{"user": 1}, time.Minute, IPLimitReject)
+	tracker.admit("user", "a", "in")
+	tracker.admit("user", "b", "in")
+	taken := tracker.TakeIPViolations()
+	if len(taken) != 1 {
+		t.Fatalf("expected one violation, got %v", taken)
+	}
+
+	// A failed save gives them back and later connections merge into them
+	tracker.RestoreIPViolations(taken)
+	tracker.admit("user", "b", "in")
+	tracker.admit("user", "c", "in")
+	violations := tracker.TakeIPViolations()
+	counts := map[string]int{}
+	for _, violation := range violations {
+		counts[violation.IP] = violation.Count
+	}
+	if len(violations) != 2 || counts["b"] != 2 || counts["c"] != 1 {
+		t.Errorf("expected 2 connections from b and 1 from c, got %v", violations)
+	}
+	if violations := tracker.TakeIPViolations(); len(violations) != 0 {
+		t.Errorf("expected no violation after taking them, got %v", violations)
+	}
+}
+
+func TestConnTrackerViolationLimit(t *testing.T) {
+	tracker := NewConnTracker()
+	for i := 0; i < maxIPViolations+10; i++ {
+		tracker.addViolation(IPViolation{User: "user", IP: time.Duration(i).String(), Count: 1})
+	}
+	if added := tracker.addViolation(IPViolation{User: "user", IP: "0s", Count: 1}); added {
+		t.Error("expected a pending violation to be merged")
+	}
+	violations := tracker.TakeIPViolations()
+	if len(violations) != maxIPViolations || violations[0].Count != 2 {
+		t.Errorf("expected %d violations with the first merged, got %d", maxIPViolations, len(violations))
+	}
+}
+
+func TestConnTrackerOpenSources(t *testing.T) {
+	tracker := NewConnTracker()
+	tracker.SetIPLimits(map[string]int{"user": 1}, time.Minute, IPLimitReject)
+	for _, id := range []string{"1", "2"} {
+		if !tracker.trackConnection(id, &ConnectionInfo{ID: id, User: "user", Source: "a"}) {
+			t.Fatalf("expected connection %s to be admitted", id)
+		}
+	}
+
+	// An address with open connections stays in use after the window
+	tracker.userIPs["user"]["a"] = time.Now().Add(-2 * time.Minute)
+	tracker.untrackConnection("1")
+	if tracker.admit("user", "b", "in") {
+		t.Error("expected b to be rejected while a has an open connection")
+	}
+	tracker.untrackConnection("2")
+	tracker.untrackConnection("2")
+	if len(tracker.userSources) != 0 {
+		t.Errorf("expected no open sources, got %v", tracker.userSources)
+	}
+	tracker.userIPs["user"]["a"] = time.Now().Add(-2 * time.Minute)
+	if !tracker.admit("user", "b", "in") {
+		t.Error("expected b to be admitted once a closed its connections")
+	}
+
+	tracker.trackConnection("3", &ConnectionInfo{ID: "3", User: "user", Source: "b"})
+	if closed := tracker.CloseConnByUser("user"); closed != 1 || len(tracker.userSources) != 0 {
+		t.Errorf("expected one closed connection and no open sources, got %d and %v", closed, tracker.userSources)
+	}
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"net"
+
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/common/network"
+	"golang.org/x/time/rate"
+)
+
+// Upload and download limits of a user in bytes per second, shared by all of its connections
+type userRate struct {
+	up   *rate.Limiter
+	down *rate.Limiter
+}
+
+func newRateLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	// One second worth of traffic may pass at once
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+}
+
+func setRateLimit(limiter *rate.Limiter, bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetBurst(int(bytesPerSec))
+	limiter.SetLimit(rate.Limit(bytesPerSec))
+}
+
+// Wait for n bytes, split into bursts since a single wait can not exceed the burst size.
+// Besides a closed connection, a wait fails only if the limit shrinks meanwhile, then it is retried with the new burst.
+func waitRate(ctx context.Context, limiter *rate.Limiter, n int) error {
+	for n > 0 && limiter.Limit() != rate.Inf {
+		chunk := n
+		if burst := limiter.Burst(); burst > 0 && chunk > burst {
+			chunk = burst
+		}
+		if limiter.WaitN(ctx, chunk) == nil {
+			n -= chunk
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Waits of a connection end when it is closed
+type rateConn struct {
+	net.Conn
+	rate   *userRate
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newRateConn(conn net.Conn, r *userRate) *rateConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rateConn{Conn: conn, rate: r, ctx: ctx, cancel: cancel}
+}
+
+func (c *rateConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if waitErr := waitRate(c.ctx, c.rate.up, n); err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *rateConn) Write(p []byte) (int, error) {
+	err := waitRate(c.ctx, c.rate.down, len(p))
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *rateConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+func (c *rateConn) Upstream() any {
+	return c.Conn
+}
+
+type ratePacketConn struct {
+	network.PacketConn
+	rate   *userRate
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newRatePacketConn(conn network.PacketConn, r *userRate) *ratePacketConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ratePacketConn{PacketConn: conn, rate: r, ctx: ctx, cancel: cancel}
+}
+
+func (c *ratePacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	destination, err := c.PacketConn.ReadPacket(buffer)
+	if err == nil {
+		err = waitRate(c.ctx, c.rate.up, buffer.Len())
+	}
+	return destination, err
+}
+
+func (c *ratePacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	err := waitRate(c.ctx, c.rate.down, buffer.Len())
+	if err != nil {
+		return err
+	}
+	return c.PacketConn.WritePacket(buffer, destination)
+}
+
+func (c *ratePacketConn) Close() error {
+	c.cancel()
+	return c.PacketConn.Close()
+}
+
+func (c *ratePacketConn) Upstream() any {
+	return c.PacketConn
+}
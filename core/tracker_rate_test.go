@@ -0,0 +1,62 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// A connection which reads and writes any amount at once
+type nopConn struct {
+	net.Conn
+}
+
+func (nopConn) Read(p []byte) (int, error)  { return len(p), nil }
+func (nopConn) Write(p []byte) (int, error) { return len(p), nil }
+func (nopConn) Close() error                { return nil }
+
+func TestRateConnClose(t *testing.T) {
+	r := &userRate{up: newRateLimiter(10), down: newRateLimiter(10)}
+	conn := newRateConn(nopConn{}, r)
+	if n, err := conn.Write(make([]byte, 10)); n != 10 || err != nil {
+		t.Fatalf("expected the burst to pass, got %d, %v", n, err)
+	}
+
+	// A write waiting for the limit ends when the connection is closed
+	done := make(chan error)
+	go func() {
+		_, err := conn.Write(make([]byte, 100))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected the write to fail after close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the write to end after close")
+	}
+}
+
+// Connections of users without a limit are wrapped as well, compare them to plain ones
+func BenchmarkRateConn(b *testing.B) {
+	p := make([]byte, 16*1024)
+	conns := []struct {
+		name string
+		conn net.Conn
+	}{
+		{"plain", nopConn{}},
+		{"unlimited", newRateConn(nopConn{}, &userRate{up: newRateLimiter(0), down: newRateLimiter(0)})},
+	}
+	for _, c := range conns {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(p)))
+			for i := 0; i < b.N; i++ {
+				c.conn.Read(p)
+				c.conn.Write(p)
+			}
+		})
+	}
+}
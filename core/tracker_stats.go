@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,11 +20,34 @@ type Counter struct {
 	write *atomic.Int64
 }
 
+// Traffic of a user through one inbound and outbound
+type routeKey struct {
+	user     string
+	inbound  string
+	outbound string
+}
+
+// Upload and download of an inbound, outbound or user since the tracker started
+type TrafficTotal struct {
+	Resource string
+	Tag      string
+	Up       int64
+	Down     int64
+}
+
+type totalKey struct {
+	resource string
+	tag      string
+}
+
 type StatsTracker struct {
 	access    sync.Mutex
 	inbounds  map[string]Counter
 	outbounds map[string]Counter
 	users     map[string]Counter
+	routes    map[routeKey]Counter
+	rates     map[string]*userRate
+	totals    map[totalKey]*TrafficTotal // traffic already taken by GetStats
 }
 
 func NewStatsTracker() *StatsTracker {
@@ -31,7 +55,46 @@ func NewStatsTracker() *StatsTracker {
 		inbounds:  make(map[string]Counter),
 		outbounds: make(map[string]Counter),
 		users:     make(map[string]Counter),
+		routes:    make(map[routeKey]Counter),
+		rates:     make(map[string]*userRate),
+		totals:    make(map[totalKey]*TrafficTotal),
+	}
+}
+
+// Replace the upload and download limits of users in bytes per second.
+// Every connection of a user shares its limiters, so open connections follow the new limits right away.
+func (c *StatsTracker) SetRateLimits(up map[string]int64, down map[string]int64) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	for user, r := range c.rates {
+		setRateLimit(r.up, up[user])
+		setRateLimit(r.down, down[user])
+	}
+	for user, limit := range up {
+		if _, ok := c.rates[user]; !ok {
+			c.rates[user] = &userRate{up: newRateLimiter(limit), down: newRateLimiter(down[user])}
+		}
+	}
+	for user, limit := range down {
+		if _, ok := c.rates[user]; !ok {
+			c.rates[user] = &userRate{up: newRateLimiter(up[user]), down: newRateLimiter(limit)}
+		}
+	}
+}
+
+// The limiters of a user, unlimited until SetRateLimits gives it a limit
+func (c *StatsTracker) getRate(user string) *userRate {
+	if user == "" {
+		return nil
+	}
+	c.access.Lock()
+	defer c.access.Unlock()
+	r, ok := c.rates[user]
+	if !ok {
+		r = &userRate{up: newRateLimiter(0), down: newRateLimiter(0)}
+		c.rates[user] = r
 	}
+	return r
 }
 
 func (c *StatsTracker) getReadCounters(inbound string, outbound string, user string) ([]*atomic.Int64, []*atomic.Int64) {
@@ -51,6 +114,15 @@ func (c *StatsTracker) getReadCounters(inbound string, outbound string, user str
 	if user != "" {
 		readCounter = append(readCounter, c.loadOrCreateCounter(&c.users, user).read)
 		writeCounter = append(writeCounter, c.users[user].write)
+
+		key := routeKey{user: user, inbound: inbound, outbound: outbound}
+		route, loaded := c.routes[key]
+		if !loaded {
+			route = Counter{read: &atomic.Int64{}, write: &atomic.Int64{}}
+			c.routes[key] = route
+		}
+		readCounter = append(readCounter, route.read)
+		writeCounter = append(writeCounter, route.write)
 	}
 	return readCounter, writeCounter
 }
@@ -65,14 +137,25 @@ func (c *StatsTracker) loadOrCreateCounter(obj *map[string]Counter, name string)
 	return counter
 }
 
+// Connections of every user are rate limited, also without a limit, so a limit set later applies to
+// open connections. An unlimited limiter costs a lock per read and write, some tens of nanoseconds
+// next to the network I/O, see BenchmarkRateConn.
 func (c *StatsTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
 	readCounter, writeCounter := c.getReadCounters(metadata.Inbound, matchOutbound.Tag(), metadata.User)
-	return bufio.NewInt64CounterConn(conn, readCounter, writeCounter)
+	conn = bufio.NewInt64CounterConn(conn, readCounter, writeCounter)
+	if r := c.getRate(metadata.User); r != nil {
+		conn = newRateConn(conn, r)
+	}
+	return conn
 }
 
 func (c *StatsTracker) RoutedPacketConnection(ctx context.Context, conn network.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) network.PacketConn {
 	readCounter, writeCounter := c.getReadCounters(metadata.Inbound, matchOutbound.Tag(), metadata.User)
-	return bufio.NewInt64CounterPacketConn(conn, readCounter, nil, writeCounter, nil)
+	conn = bufio.NewInt64CounterPacketConn(conn, readCounter, nil, writeCounter, nil)
+	if r := c.getRate(metadata.User); r != nil {
+		conn = newRatePacketConn(conn, r)
+	}
+	return conn
 }
 
 func (c *StatsTracker) GetStats() *[]model.Stats {
@@ -86,6 +169,7 @@ func (c *StatsTracker) GetStats() *[]model.Stats {
 		down := counter.write.Swap(0)
 		up := counter.read.Swap(0)
 		if down > 0 || up > 0 {
+			c.addTotal("inbound", inbound, up, down)
 			s = append(s, model.Stats{
 				DateTime:  dt,
 				Resource:  "inbound",
@@ -106,6 +190,7 @@ func (c *StatsTracker) GetStats() *[]model.Stats {
 		down := counter.write.Swap(0)
 		up := counter.read.Swap(0)
 		if down > 0 || up > 0 {
+			c.addTotal("outbound", outbound, up, down)
 			s = append(s, model.Stats{
 				DateTime:  dt,
 				Resource:  "outbound",
@@ -126,6 +211,7 @@ func (c *StatsTracker) GetStats() *[]model.Stats {
 		down := counter.write.Swap(0)
 		up := counter.read.Swap(0)
 		if down > 0 || up > 0 {
+			c.addTotal("user", user, up, down)
 			s = append(s, model.Stats{
 				DateTime:  dt,
 				Resource:  "user",
@@ -141,5 +227,121 @@ func (c *StatsTracker) GetStats() *[]model.Stats {
 			})
 		}
 	}
+
+	for route, counter := range c.routes {
+		down := counter.write.Swap(0)
+		up := counter.read.Swap(0)
+		if down > 0 || up > 0 {
+			s = append(s, model.Stats{
+				DateTime:  dt,
+				Resource:  "userRoute",
+				Tag:       route.user,
+				Direction: false,
+				Traffic:   down,
+				Inbound:   route.inbound,
+				Outbound:  route.outbound,
+			}, model.Stats{
+				DateTime:  dt,
+				Resource:  "userRoute",
+				Tag:       route.user,
+				Direction: true,
+				Traffic:   up,
+				Inbound:   route.inbound,
+				Outbound:  route.outbound,
+			})
+		}
+	}
 	return &s
 }
+
+// Give back traffic taken by GetStats which could not be saved, so the next call returns it again
+func (c *StatsTracker) Restore(stats *[]model.Stats) {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	for _, stat := range *stats {
+		var counter Counter
+		switch stat.Resource {
+		case "inbound":
+			counter = c.loadOrCreateCounter(&c.inbounds, stat.Tag)
+		case "outbound":
+			counter = c.loadOrCreateCounter(&c.outbounds, stat.Tag)
+		case "user":
+			counter = c.loadOrCreateCounter(&c.users, stat.Tag)
+		case "userRoute":
+			key := routeKey{user: stat.Tag, inbound: stat.Inbound, outbound: stat.Outbound}
+			route, loaded := c.routes[key]
+			if !loaded {
+				route = Counter{read: &atomic.Int64{}, write: &atomic.Int64{}}
+				c.routes[key] = route
+			}
+			counter = route
+		default:
+			continue
+		}
+		if stat.Direction {
+			counter.read.Add(stat.Traffic)
+		} else {
+			counter.write.Add(stat.Traffic)
+		}
+		// The counters hold it again, it must not be in the totals twice
+		if total, ok := c.totals[totalKey{resource: stat.Resource, tag: stat.Tag}]; ok {
+			if stat.Direction {
+				total.Up -= stat.Traffic
+			} else {
+				total.Down -= stat.Traffic
+			}
+		}
+	}
+}
+
+func (c *StatsTracker) addTotal(resource string, tag string, up int64, down int64) {
+	key := totalKey{resource: resource, tag: tag}
+	total, ok := c.totals[key]
+	if !ok {
+		total = &TrafficTotal{Resource: resource, Tag: tag}
+		c.totals[key] = total
+	}
+	total.Up += up
+	total.Down += down
+}
+
+// Traffic of every inbound, outbound and user since the tracker started, including the bytes
+// GetStats has not taken yet. The totals only grow, so they fit monotonic counters.
+func (c *StatsTracker) Totals() []TrafficTotal {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	merged := make(map[totalKey]TrafficTotal, len(c.totals))
+	for key, total := range c.totals {
+		merged[key] = *total
+	}
+	pending := map[string]map[string]Counter{
+		"inbound":  c.inbounds,
+		"outbound": c.outbounds,
+		"user":     c.users,
+	}
+	for resource, counters := range pending {
+		for tag, counter := range counters {
+			key := totalKey{resource: resource, tag: tag}
+			total := merged[key]
+			total.Resource = resource
+			total.Tag = tag
+			total.Up += counter.read.Load()
+			total.Down += counter.write.Load()
+			merged[key] = total
+		}
+	}
+
+	result := make([]TrafficTotal, 0, len(merged))
+	for _, total := range merged {
+		result = append(result, total)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Resource != result[j].Resource {
+			return result[i].Resource < result[j].Resource
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func statsTraffic(stats *[]model.Stats) map[string]int64 {
+	traffic := map[string]int64{}
+	for _, stat := range *stats {
+		key := stat.Resource + "/" + stat.Tag + "/" + stat.Inbound + "/" + stat.Outbound
+		if stat.Direction {
+			key += "/up"
+		} else {
+			key += "/down"
+		}
+		traffic[key] += stat.Traffic
+	}
+	return traffic
+}
+
+func TestStatsTrackerRestore(t *testing.T) {
+	tracker := NewStatsTracker()
+	read, write := tracker.getReadCounters("in", "out", "user")
+	for _, counter := range read {
+		counter.Add(100)
+	}
+	for _, counter := range write {
+		counter.Add(300)
+	}
+	totals := tracker.Totals()
+
+	// Traffic of a failed save is returned by the next call, and counted once in the totals
+	taken := tracker.GetStats()
+	tracker.Restore(taken)
+	read[0].Add(5)
+	if got := tracker.Totals(); len(got) != 3 || got[0].Up != totals[0].Up+5 || got[1] != totals[1] || got[2] != totals[2] {
+		t.Errorf("expected totals %v with 5 more up for the inbound, got %v", totals, got)
+	}
+	again := statsTraffic(tracker.GetStats())
+	want := statsTraffic(taken)
+	want["inbound/in///up"] += 5
+	if len(again) != len(want) {
+		t.Fatalf("expected %v, got %v", want, again)
+	}
+	for key, traffic := range want {
+		if again[key] != traffic {
+			t.Errorf("%s: expected %d, got %d", key, traffic, again[key])
+		}
+	}
+	if got := tracker.Totals(); got[0].Up != totals[0].Up+5 || got[0].Down != totals[0].Down {
+		t.Errorf("expected the inbound total to be %d/%d, got %d/%d", totals[0].Up+5, totals[0].Down, got[0].Up, got[0].Down)
+	}
+	if stats := tracker.GetStats(); len(*stats) != 0 {
+		t.Errorf("expected no traffic left, got %v", *stats)
+	}
+}
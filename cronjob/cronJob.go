@@ -23,12 +23,19 @@ func (c *CronJob) Start(loc *time.Location, trafficAge int) error {
 		c.cron.AddJob("@every 10s", NewStatsJob(trafficAge > 0))
 		// Start expiry job
 		c.cron.AddJob("@every 1m", NewDepleteJob())
-		// Start deleting old stats
+		// Warn about clients approaching their volume or expiry
+		c.cron.AddJob("@every 1m", NewWarnJob())
+		// Reset client traffic on schedule
+		c.cron.AddJob("@every 1m", NewResetJob())
+		// Roll up stats into hourly and daily totals and delete old ones
 		if trafficAge > 0 {
+			c.cron.AddJob("@every 10m", NewRollupStatsJob(loc))
 			c.cron.AddJob("@daily", NewDelStatsJob(trafficAge))
 		}
 		// Start core if it is not running
 		c.cron.AddJob("@every 5s", NewCheckCoreJob())
+		// Send queued webhooks
+		c.cron.AddJob("@every 10s", NewWebhookJob())
 	}()
 
 	return nil
@@ -7,6 +7,7 @@ import (
 
 type DelStatsJob struct {
 	service.StatsService
+	service.SettingService
 	trafficAge int
 }
 
@@ -23,4 +24,20 @@ func (s *DelStatsJob) Run() {
 		return
 	}
 	logger.Debug("Stats older than ", s.trafficAge, " days were deleted")
+
+	// Each rollup tier has its own retention
+	hourlyAge, err := s.SettingService.GetHourlyAge()
+	if err != nil {
+		logger.Warning("Deleting old hourly statistics failed: ", err)
+		return
+	}
+	dailyAge, err := s.SettingService.GetDailyAge()
+	if err != nil {
+		logger.Warning("Deleting old daily statistics failed: ", err)
+		return
+	}
+	err = s.StatsService.DelOldRollups(hourlyAge, dailyAge)
+	if err != nil {
+		logger.Warning("Deleting old rolled up statistics failed: ", err)
+	}
 }
@@ -0,0 +1,35 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type ResetJob struct {
+	service.SettingService
+	service.InboundService
+}
+
+func NewResetJob() *ResetJob {
+	return new(ResetJob)
+}
+
+func (s *ResetJob) Run() {
+	loc, err := s.SettingService.GetTimeLocation()
+	if err != nil {
+		logger.Warning("Reset client traffic failed: ", err)
+		return
+	}
+	inboundIds, err := s.InboundService.ResetClients(loc)
+	if err != nil {
+		logger.Warning("Reset client traffic failed: ", err)
+		return
+	}
+	if len(inboundIds) > 0 {
+		err := s.InboundService.RestartInbounds(database.GetDB(), inboundIds)
+		if err != nil {
+			logger.Error("unable to restart inbounds: ", err)
+		}
+	}
+}
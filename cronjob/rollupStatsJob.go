@@ -0,0 +1,26 @@
+package cronjob
+
+import (
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type RollupStatsJob struct {
+	service.StatsService
+	loc *time.Location
+}
+
+func NewRollupStatsJob(loc *time.Location) *RollupStatsJob {
+	return &RollupStatsJob{
+		loc: loc,
+	}
+}
+
+func (s *RollupStatsJob) Run() {
+	err := s.StatsService.RollupStats(s.loc)
+	if err != nil {
+		logger.Warning("Rolling up statistics failed: ", err)
+	}
+}
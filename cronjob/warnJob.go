@@ -0,0 +1,21 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type WarnJob struct {
+	service.WarningService
+}
+
+func NewWarnJob() *WarnJob {
+	return new(WarnJob)
+}
+
+func (s *WarnJob) Run() {
+	err := s.WarningService.WarnClients()
+	if err != nil {
+		logger.Warning("Warn clients failed: ", err)
+	}
+}
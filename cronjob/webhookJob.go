@@ -0,0 +1,21 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type WebhookJob struct {
+	service.WebhookService
+}
+
+func NewWebhookJob() *WebhookJob {
+	return new(WebhookJob)
+}
+
+func (s *WebhookJob) Run() {
+	err := s.WebhookService.DeliverPending()
+	if err != nil {
+		logger.Warning("Deliver webhooks failed: ", err)
+	}
+}
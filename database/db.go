@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"os"
 	"path"
+	"strconv"
 
 	"github.com/alireza0/s-ui/config"
 	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -22,15 +24,89 @@ func initUser() error {
 		return err
 	}
 	if count == 0 {
+		password, err := common.HashPassword("admin")
+		if err != nil {
+			return err
+		}
 		user := &model.User{
 			Username: "admin",
-			Password: "admin",
+			Password: password,
 		}
 		return db.Create(user).Error
 	}
 	return nil
 }
 
+// Passwords saved before they were hashed are hashed in place on every start,
+// so upgraded panels do not depend on the migration of a version bump
+func initPasswordHashes() error {
+	var users []model.User
+	err := db.Model(&model.User{}).Select("id, password").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if common.IsPasswordHashed(user.Password) {
+			continue
+		}
+		hashedPassword, err := common.HashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		err = db.Model(&model.User{}).Where("id = ?", user.Id).Update("password", hashedPassword).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clients created before subscription tokens get one each
+func initSubTokens() error {
+	var ids []uint
+	err := db.Model(&model.Client{}).Where("sub_token IS NULL OR sub_token = ''").Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = db.Model(&model.Client{}).Where("id = ?", id).Update("sub_token", common.NewSubToken()).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// API tokens saved before they were hashed stop matching, hash them in place
+func initTokenHashes() error {
+	var tokens []model.Tokens
+	err := db.Model(&model.Tokens{}).Select("id, token").Find(&tokens).Error
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if common.IsTokenHashed(token.Token) {
+			continue
+		}
+		err = db.Model(&model.Tokens{}).Where("id = ?", token.Id).Update("token", common.HashToken(token.Token)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name lookups of subscriptions stay on for existing panels, whose links use client names.
+// The choice is saved on the first start, so a new panel does not count as existing later.
+func initSubNameLookup(existing bool) error {
+	var count int64
+	err := db.Model(&model.Setting{}).Where("key = ?", "subNameLookup").Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	return db.Create(&model.Setting{Key: "subNameLookup", Value: strconv.FormatBool(existing)}).Error
+}
+
 func OpenDB(dbPath string) error {
 	dir := path.Dir(dbPath)
 	err := os.MkdirAll(dir, 01740)
@@ -63,6 +139,9 @@ func InitDB(dbPath string) error {
 		return err
 	}
 
+	// Subscriptions of panels installed before tokens are found by the client name
+	existing := db.Migrator().HasTable(&model.Setting{})
+
 	// Default Outbounds
 	if !db.Migrator().HasTable(&model.Outbound{}) {
 		db.Migrator().CreateTable(&model.Outbound{})
@@ -81,8 +160,16 @@ func InitDB(dbPath string) error {
 		&model.Endpoint{},
 		&model.User{},
 		&model.Tokens{},
+		&model.Session{},
+		&model.Ban{},
+		&model.Webhook{},
+		&model.WebhookDelivery{},
 		&model.Stats{},
+		&model.HourlyStats{},
+		&model.DailyStats{},
 		&model.Client{},
+		&model.ClientUsage{},
+		&model.ClientWarning{},
 		&model.Changes{},
 		&model.Subscription{},
 	)
@@ -93,6 +180,22 @@ func InitDB(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	err = initPasswordHashes()
+	if err != nil {
+		return err
+	}
+	err = initSubTokens()
+	if err != nil {
+		return err
+	}
+	err = initTokenHashes()
+	if err != nil {
+		return err
+	}
+	err = initSubNameLookup(existing)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
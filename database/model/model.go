@@ -16,10 +16,18 @@ type Tls struct {
 }
 
 type User struct {
-	Id         uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	Username   string `json:"username" form:"username"`
-	Password   string `json:"password" form:"password"`
-	LastLogins string `json:"lastLogin"`
+	Id            uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Username      string `json:"username" form:"username"`
+	Password      string `json:"password" form:"password"`
+	LastLogins    string `json:"lastLogin"`
+	Role          string `json:"role" form:"role" gorm:"default:owner"`
+	MaxClients    int    `json:"maxClients" form:"maxClients"`       // reseller quota, 0 = unlimited
+	MaxVolume     int64  `json:"maxVolume" form:"maxVolume"`         // reseller quota of total clients volume, 0 = unlimited
+	MaxExpiryDays int    `json:"maxExpiryDays" form:"maxExpiryDays"` // reseller quota of client expiry from now, 0 = unlimited
+	TotpEnabled   bool   `json:"totpEnabled"`
+	TotpSecret    string `json:"-"`
+	TotpLastStep  int64  `json:"-"`
+	RecoveryCodes string `json:"-"` // JSON array of hashed one-time recovery codes
 }
 
 type Client struct {
@@ -35,6 +43,52 @@ type Client struct {
 	Up       int64           `json:"up" form:"up"`
 	Desc     string          `json:"desc" form:"desc"`
 	Group    string          `json:"group" form:"group"`
+	OwnerId  uint            `json:"ownerId" form:"ownerId"` // reseller who created the client, 0 = panel
+
+	// Traffic reset policy: "", "daily", "weekly" on ResetDay (0 = Sunday), "monthly" on ResetDay or "days" every ResetDays
+	ResetMode      string `json:"resetMode" form:"resetMode"`
+	ResetDay       int    `json:"resetDay" form:"resetDay"`
+	ResetDays      int    `json:"resetDays" form:"resetDays"`
+	LastReset      int64  `json:"lastReset" form:"lastReset"`
+	DisabledReason string `json:"disabledReason" form:"disabledReason"` // "volume" or "expiry" when disabled by DepleteJob
+
+	// Seconds of validity counted from the first traffic, converted to Expiry at that point
+	ExpiryAfterUse int64 `json:"expiryAfterUse" form:"expiryAfterUse"`
+	FirstUse       int64 `json:"firstUse" form:"firstUse"`
+
+	// Distinct source addresses allowed within the limit window, 0 = unlimited
+	IPLimit int `json:"ipLimit" form:"ipLimit"`
+	// Speed limits in bytes per second shared by all connections, 0 = unlimited
+	UpLimit   int64 `json:"upLimit" form:"upLimit"`
+	DownLimit int64 `json:"downLimit" form:"downLimit"`
+
+	// Random subscription path. A rotated token keeps working until PrevSubTokenExpiry.
+	SubToken           string `json:"subToken" form:"subToken" gorm:"index"`
+	PrevSubToken       string `json:"prevSubToken" form:"prevSubToken" gorm:"index"`
+	PrevSubTokenExpiry int64  `json:"prevSubTokenExpiry" form:"prevSubTokenExpiry"`
+}
+
+type ClientWarning struct {
+	Id        uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientId  uint   `json:"clientId" gorm:"index"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`      // "volume" or "expiry"
+	Threshold int    `json:"threshold"` // percent of the volume, or days before the expiry
+	Period    int64  `json:"period"`    // last reset for volume warnings, expiry date for expiry warnings
+	DateTime  int64  `json:"dateTime"`
+	Notified  bool   `json:"notified"`
+	Error     string `json:"error"`
+}
+
+type ClientUsage struct {
+	Id       uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientId uint   `json:"clientId" gorm:"index"`
+	Name     string `json:"name"`
+	Up       int64  `json:"up"`
+	Down     int64  `json:"down"`
+	Volume   int64  `json:"volume"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
 }
 
 type Stats struct {
@@ -44,6 +98,33 @@ type Stats struct {
 	Tag       string `json:"tag"`
 	Direction bool   `json:"direction"`
 	Traffic   int64  `json:"traffic"`
+	// Inbound and outbound of the user's traffic for the "userRoute" resource
+	Inbound  string `json:"inbound,omitempty"`
+	Outbound string `json:"outbound,omitempty"`
+}
+
+// Stats summed per hour, DateTime is the start of the hour
+type HourlyStats struct {
+	Id        uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	DateTime  int64  `json:"dateTime" gorm:"index"`
+	Resource  string `json:"resource"`
+	Tag       string `json:"tag"`
+	Direction bool   `json:"direction"`
+	Traffic   int64  `json:"traffic"`
+	Inbound   string `json:"inbound,omitempty"`
+	Outbound  string `json:"outbound,omitempty"`
+}
+
+// Stats summed per day of the panel time location, DateTime is the start of the day
+type DailyStats struct {
+	Id        uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	DateTime  int64  `json:"dateTime" gorm:"index"`
+	Resource  string `json:"resource"`
+	Tag       string `json:"tag"`
+	Direction bool   `json:"direction"`
+	Traffic   int64  `json:"traffic"`
+	Inbound   string `json:"inbound,omitempty"`
+	Outbound  string `json:"outbound,omitempty"`
 }
 
 type Changes struct {
@@ -53,15 +134,65 @@ type Changes struct {
 	Key      string          `json:"key"`
 	Action   string          `json:"action"`
 	Obj      json.RawMessage `json:"obj"`
+	ObjId    string          `json:"objId" gorm:"index"`
+	Before   json.RawMessage `json:"before"`
+	Diff     json.RawMessage `json:"diff"`
+}
+
+type Webhook struct {
+	Id      uint            `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Desc    string          `json:"desc" form:"desc"`
+	Url     string          `json:"url" form:"url"`
+	Secret  string          `json:"-"`
+	Events  json.RawMessage `json:"events" form:"events"`
+	Enabled bool            `json:"enabled" form:"enabled" gorm:"default:true"`
+}
+
+type WebhookDelivery struct {
+	Id           uint64          `json:"id" gorm:"primaryKey;autoIncrement"`
+	WebhookId    uint            `json:"webhookId" gorm:"index"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status" gorm:"index"`
+	Attempts     int             `json:"attempts"`
+	ResponseCode int             `json:"responseCode"`
+	LastError    string          `json:"lastError"`
+	NextAttempt  int64           `json:"nextAttempt"`
+	DateTime     int64           `json:"dateTime"`
+}
+
+type Session struct {
+	Id         uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	Token      string `json:"-" gorm:"uniqueIndex"`
+	UserId     uint   `json:"userId" gorm:"index"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"userAgent"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastActive int64  `json:"lastActive"`
+	Expiry     int64  `json:"expiry"`
+}
+
+type Ban struct {
+	Id       uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Scope    string `json:"scope" gorm:"uniqueIndex:idx_ban_scope_value"`
+	Value    string `json:"value" gorm:"uniqueIndex:idx_ban_scope_value"`
+	Failures int    `json:"failures"`
+	Reason   string `json:"reason"`
+	Since    int64  `json:"since"`
+	Until    int64  `json:"until"`
 }
 
 type Tokens struct {
-	Id     uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	Desc   string `json:"desc" form:"desc"`
-	Token  string `json:"token" form:"token"`
-	Expiry int64  `json:"expiry" form:"expiry"`
-	UserId uint   `json:"userId" form:"userId"`
-	User   *User  `json:"user" gorm:"foreignKey:UserId;references:Id"`
+	Id         uint            `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Desc       string          `json:"desc" form:"desc"`
+	Token      string          `json:"token" form:"token"`
+	Expiry     int64           `json:"expiry" form:"expiry"`
+	Scopes     json.RawMessage `json:"scopes" form:"scopes"`
+	AllowedIPs json.RawMessage `json:"allowedIps" form:"allowedIps"`
+	LastUsed   int64           `json:"lastUsed"`
+	LastIP     string          `json:"lastIp"`
+	UserId     uint            `json:"userId" form:"userId"`
+	User       *User           `json:"user" gorm:"foreignKey:UserId;references:Id"`
 }
 
 type Subscription struct {
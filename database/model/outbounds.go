@@ -15,6 +15,7 @@ type Outbound struct {
 	FraudScore     int             `json:"fraudScore,omitempty" form:"fraudScore"`
 	IPType         string          `json:"ipType,omitempty" form:"ipType"`
 	Available      bool            `json:"available,omitempty" form:"available"`
+	Latency        int64           `json:"latency,omitempty" form:"latency"` // milliseconds of the last test, -1 when it failed
 	SubscriptionId *uint           `json:"subscriptionId,omitempty" form:"subscriptionId"` // nil = manual, value = from subscription
 }
 
@@ -52,6 +53,8 @@ func (o *Outbound) UnmarshalJSON(data []byte) error {
 	delete(raw, "ipType")
 	if val, ok := raw["available"].(bool); ok { o.Available = val }
 	delete(raw, "available")
+	if val, ok := raw["latency"].(float64); ok { o.Latency = int64(val) }
+	delete(raw, "latency")
 	if val, ok := raw["subscriptionId"].(float64); ok { 
 		id := uint(val)
 		o.SubscriptionId = &id 
@@ -89,6 +92,7 @@ func (o Outbound) MarshalJSON() ([]byte, error) {
 	if o.LastTestTime > 0 {
 		combined["fraudScore"] = o.FraudScore
 		combined["available"] = o.Available
+		combined["latency"] = o.Latency
 	}
 	if o.IPType != "" {
 		combined["ipType"] = o.IPType
@@ -104,7 +108,7 @@ func (o Outbound) MarshalJSON() ([]byte, error) {
 			// Skip internal fields that might be incorrectly stored in Options
 			if k == "city" || k == "country" || k == "region" || 
 			   k == "landingIP" || k == "lastTestTime" || k == "subscriptionId" ||
-			   k == "fraudScore" || k == "ipType" || k == "available" {
+			   k == "fraudScore" || k == "ipType" || k == "available" || k == "latency" {
 				continue
 			}
 			combined[k] = v
@@ -131,7 +135,7 @@ func (o Outbound) SingBoxJSON() ([]byte, error) {
 			// Skip internal fields that might be incorrectly stored in Options
 			if k == "city" || k == "country" || k == "region" || 
 			   k == "landingIP" || k == "lastTestTime" || k == "subscriptionId" ||
-			   k == "fraudScore" || k == "ipType" || k == "available" {
+			   k == "fraudScore" || k == "ipType" || k == "available" || k == "latency" {
 				continue
 			}
 			combined[k] = v
@@ -152,6 +152,7 @@ export default {
     unRules: "Username can not be empty",
     password: "Password",
     pwRules: "Password can not be empty",
+    code: "Two-factor code or recovery code",
   },
   menu: {
     logout: "Logout",
@@ -19,6 +19,7 @@ export interface Client {
   down: number
   desc: string
   group: string
+  subToken?: string
 }
 
 const defaultClient: Client = {
@@ -150,6 +150,7 @@ set_admin() {
 
 view_admin() {
     /usr/local/s-ui/sui admin -show
+    echo -e "Passwords are stored hashed. Use option ${green}6${plain} to set a new one."
     before_show_menu
 }
 
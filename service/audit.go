@@ -0,0 +1,207 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+type ChangesFilter struct {
+	Actor  string
+	Key    string
+	Action string
+	ObjId  string
+	From   int64
+	To     int64
+	Offset int
+	Limit  int
+}
+
+// Column and value which locate the object of a save, or an empty column if there is none
+func auditLookup(obj string, act string, data json.RawMessage) (string, interface{}) {
+	nameColumn := "tag"
+	if obj == "tls" || obj == "clients" {
+		nameColumn = "name"
+	}
+	switch act {
+	case "edit", "rotateSubToken":
+		var object struct {
+			Id uint `json:"id"`
+		}
+		if json.Unmarshal(data, &object) == nil && object.Id > 0 {
+			return "id", object.Id
+		}
+	case "new":
+		var object map[string]interface{}
+		if json.Unmarshal(data, &object) == nil && object[nameColumn] != nil {
+			return nameColumn, object[nameColumn]
+		}
+	case "del":
+		var value interface{}
+		if json.Unmarshal(data, &value) == nil {
+			if nameColumn == "name" {
+				return "id", value
+			}
+			return "tag", value
+		}
+	}
+	return "", nil
+}
+
+// The row matching column, the newest one after "new" since names of tls and clients may repeat
+func findAudited(tx *gorm.DB, dest interface{}, act string, column string, value interface{}) error {
+	if act == "new" {
+		return tx.Where(column+" = ?", value).Last(dest).Error
+	}
+	return tx.Where(column+" = ?", value).First(dest).Error
+}
+
+func firstAsJson(tx *gorm.DB, dest interface{}, act string, column string, value interface{}) (json.RawMessage, error) {
+	err := findAudited(tx, dest, act, column, value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(dest)
+}
+
+const redacted = "***"
+
+// Hide credentials of settings, which end up in the change log and webhook payloads
+func redactSettings(settings map[string]string) {
+	if settings["smtpPassword"] != "" {
+		settings["smtpPassword"] = redacted
+	}
+	if notifyUrl, ok := settings["notifyUrl"]; ok {
+		settings["notifyUrl"] = redactUrl(notifyUrl)
+	}
+}
+
+// Keep only the scheme and host of a url with a user, path or query, where tokens are passed
+func redactUrl(value string) string {
+	if value == "" {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" && u.Fragment == "" {
+		return value
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
+
+func redactSettingsJson(data json.RawMessage) (json.RawMessage, error) {
+	var settings map[string]string
+	err := json.Unmarshal(data, &settings)
+	if err != nil {
+		return nil, err
+	}
+	redactSettings(settings)
+	return json.Marshal(settings)
+}
+
+// Snapshot the stored state of the object touched by a save, together with its id
+func (s *ConfigService) auditSnapshot(tx *gorm.DB, obj string, act string, data json.RawMessage) (json.RawMessage, string, error) {
+	switch obj {
+	case "settings":
+		var settings map[string]string
+		err := json.Unmarshal(data, &settings)
+		if err != nil {
+			return nil, "", err
+		}
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		var rows []model.Setting
+		err = tx.Model(model.Setting{}).Where("key in ?", keys).Find(&rows).Error
+		if err != nil {
+			return nil, "", err
+		}
+		current := map[string]string{}
+		for _, row := range rows {
+			current[row.Key] = row.Value
+		}
+		redactSettings(current)
+		snapshot, err := json.Marshal(current)
+		return snapshot, "", err
+	case "config":
+		var setting model.Setting
+		err := tx.Model(model.Setting{}).Where("key = ?", "config").First(&setting).Error
+		if err != nil {
+			return nil, "", nil
+		}
+		return json.RawMessage(setting.Value), "", nil
+	}
+
+	column, value := auditLookup(obj, act, data)
+	if column == "" {
+		return nil, "", nil
+	}
+	var snapshot json.RawMessage
+	var id uint
+	var err error
+	switch obj {
+	case "clients":
+		var client model.Client
+		snapshot, err = firstAsJson(tx, &client, act, column, value)
+		id = client.Id
+	case "tls":
+		var tls model.Tls
+		snapshot, err = firstAsJson(tx, &tls, act, column, value)
+		id = tls.Id
+	case "inbounds":
+		var inbound model.Inbound
+		err = findAudited(tx, &inbound, act, column, value)
+		if err == nil {
+			var full *map[string]interface{}
+			full, err = inbound.MarshalFull()
+			if err == nil {
+				snapshot, err = json.Marshal(full)
+			}
+		}
+		id = inbound.Id
+	case "outbounds":
+		var outbound model.Outbound
+		snapshot, err = firstAsJson(tx, &outbound, act, column, value)
+		id = outbound.Id
+	case "endpoints":
+		var endpoint model.Endpoint
+		snapshot, err = firstAsJson(tx, &endpoint, act, column, value)
+		id = endpoint.Id
+	case "services":
+		var srv model.Service
+		snapshot, err = firstAsJson(tx, &srv, act, column, value)
+		id = srv.Id
+	default:
+		return nil, "", nil
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return snapshot, fmt.Sprint(id), nil
+}
+
+// Fill the before snapshot, object id and field diff of a change
+func (s *ConfigService) auditChange(change *model.Changes, before json.RawMessage, after json.RawMessage, objId string) error {
+	change.Before = before
+	change.ObjId = objId
+	if before == nil || after == nil {
+		return nil
+	}
+	diff, err := common.JsonDiff(before, after)
+	if err != nil {
+		return err
+	}
+	change.Diff, err = json.Marshal(diff)
+	return err
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"bytes"
 	"encoding/json"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,86 +39,324 @@ func (s *ClientService) getById(id string) (*[]model.Client, error) {
 func (s *ClientService) GetAll() (*[]model.Client, error) {
 	db := database.GetDB()
 	var clients []model.Client
-	err := db.Model(model.Client{}).Select("`id`, `enable`, `name`, `desc`, `group`, `inbounds`, `up`, `down`, `volume`, `expiry`").Scan(&clients).Error
+	err := db.Model(model.Client{}).Select("`id`, `enable`, `name`, `desc`, `group`, `inbounds`, `up`, `down`, `volume`, `expiry`, `owner_id`, `expiry_after_use`, `reset_mode`, `ip_limit`, `up_limit`, `down_limit`").Scan(&clients).Error
 	if err != nil {
 		return nil, err
 	}
 	return &clients, nil
 }
 
-func (s *ClientService) Save(tx *gorm.DB, act string, data json.RawMessage, hostname string) ([]uint, error) {
+func (s *ClientService) GetOwnedNames(ownerId uint) ([]string, error) {
+	db := database.GetDB()
+	var names []string
+	err := db.Model(model.Client{}).Where("owner_id = ?", ownerId).Pluck("name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Save clients. A non-nil reseller limits the change to the reseller's own clients and quotas.
+// Returns the inbounds which need a restart and the clients whose connections are dropped after the commit.
+func (s *ClientService) Save(tx *gorm.DB, act string, data json.RawMessage, hostname string, reseller *model.User) ([]uint, []string, error) {
 	var err error
 	var inboundIds []uint
+	var closed []string
 
 	switch act {
 	case "new", "edit":
 		var client model.Client
 		err = json.Unmarshal(data, &client)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		err = s.applyManagedFields(tx, act, &client, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = s.applyOwnership(tx, act, []*model.Client{&client}, reseller)
+		if err != nil {
+			return nil, nil, err
 		}
 		err = s.updateLinksWithFixedInbounds(tx, []*model.Client{&client}, hostname)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if act == "edit" {
+			var oldClient model.Client
+			err = tx.Model(model.Client{}).Select("name, enable").Where("id = ?", client.Id).First(&oldClient).Error
+			if err != nil {
+				return nil, nil, err
+			}
+			if oldClient.Enable && !client.Enable {
+				closed = append(closed, oldClient.Name)
+			}
 			// Find changed inbounds
 			inboundIds, err = s.findInboundsChanges(tx, client)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		} else {
 			err = json.Unmarshal(client.Inbounds, &inboundIds)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 		err = tx.Save(&client).Error
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		err = s.checkResellerQuota(tx, reseller)
+		if err != nil {
+			return nil, nil, err
 		}
 	case "addbulk":
 		var clients []*model.Client
 		err = json.Unmarshal(data, &clients)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if len(clients) == 0 {
+			return nil, nil, common.NewError("no clients to add")
+		}
+		for _, client := range clients {
+			err = s.applyManagedFields(tx, "new", client, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		err = s.applyOwnership(tx, "new", clients, reseller)
+		if err != nil {
+			return nil, nil, err
 		}
 		err = json.Unmarshal(clients[0].Inbounds, &inboundIds)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		err = s.updateLinksWithFixedInbounds(tx, clients, hostname)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		err = tx.Save(clients).Error
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		err = s.checkResellerQuota(tx, reseller)
+		if err != nil {
+			return nil, nil, err
 		}
 	case "del":
 		var id uint
 		err = json.Unmarshal(data, &id)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		var client model.Client
 		err = tx.Where("id = ?", id).First(&client).Error
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if reseller != nil && client.OwnerId != reseller.Id {
+			return nil, nil, common.NewError("client not found")
 		}
 		err = json.Unmarshal(client.Inbounds, &inboundIds)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		err = tx.Where("id = ?", id).Delete(model.Client{}).Error
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		closed = append(closed, client.Name)
 	default:
-		return nil, common.NewErrorf("unknown action: %s", act)
+		return nil, nil, common.NewErrorf("unknown action: %s", act)
 	}
 
-	return inboundIds, nil
+	return inboundIds, closed, nil
+}
+
+// Ownership can not be changed by editing. Resellers always own what they create
+// and can not touch usage counters, which would bypass their volume quota.
+func (s *ClientService) applyOwnership(tx *gorm.DB, act string, clients []*model.Client, reseller *model.User) error {
+	for _, client := range clients {
+		if act == "edit" {
+			var oldClient model.Client
+			err := tx.Model(model.Client{}).Select("id, owner_id, up, down").Where("id = ?", client.Id).First(&oldClient).Error
+			if err != nil {
+				return err
+			}
+			if reseller != nil && oldClient.OwnerId != reseller.Id {
+				return common.NewError("client not found")
+			}
+			client.OwnerId = oldClient.OwnerId
+			if reseller != nil {
+				client.Up = oldClient.Up
+				client.Down = oldClient.Down
+			}
+		} else if reseller != nil {
+			client.Id = 0
+			client.Up = 0
+			client.Down = 0
+			client.OwnerId = reseller.Id
+		}
+		if reseller != nil && reseller.MaxExpiryDays > 0 {
+			maxExpiry := time.Now().Unix() + int64(reseller.MaxExpiryDays)*86400
+			if client.ExpiryAfterUse > 0 {
+				if client.ExpiryAfterUse > int64(reseller.MaxExpiryDays)*86400 {
+					return common.NewErrorf("expiry of %s exceeds the reseller limit of %d days", client.Name, reseller.MaxExpiryDays)
+				}
+			} else if client.Expiry <= 0 || client.Expiry > maxExpiry {
+				return common.NewErrorf("expiry of %s exceeds the reseller limit of %d days", client.Name, reseller.MaxExpiryDays)
+			}
+		}
+		if reseller != nil && reseller.MaxVolume > 0 && client.Volume <= 0 {
+			return common.NewErrorf("unlimited volume of %s is not allowed for resellers with volume quota", client.Name)
+		}
+	}
+	return nil
+}
+
+// Validate the reset policy and delayed expiry, and keep the server managed fields.
+// Fields unknown to older frontends are kept from the stored client when an edit does not send them.
+func (s *ClientService) applyManagedFields(tx *gorm.DB, act string, client *model.Client, data json.RawMessage) error {
+	if act == "edit" {
+		var oldClient model.Client
+		err := tx.Model(model.Client{}).Where("id = ?", client.Id).First(&oldClient).Error
+		if err != nil {
+			return err
+		}
+		var sent map[string]json.RawMessage
+		json.Unmarshal(data, &sent)
+		if _, ok := sent["resetMode"]; !ok {
+			client.ResetMode = oldClient.ResetMode
+			client.ResetDay = oldClient.ResetDay
+			client.ResetDays = oldClient.ResetDays
+		}
+		if _, ok := sent["expiryAfterUse"]; !ok {
+			client.ExpiryAfterUse = oldClient.ExpiryAfterUse
+		}
+		if _, ok := sent["ipLimit"]; !ok {
+			client.IPLimit = oldClient.IPLimit
+		}
+		if _, ok := sent["upLimit"]; !ok {
+			client.UpLimit = oldClient.UpLimit
+		}
+		if _, ok := sent["downLimit"]; !ok {
+			client.DownLimit = oldClient.DownLimit
+		}
+		client.FirstUse = oldClient.FirstUse
+		client.LastReset = oldClient.LastReset
+		// Subscription tokens only change by rotation
+		client.SubToken = oldClient.SubToken
+		client.PrevSubToken = oldClient.PrevSubToken
+		client.PrevSubTokenExpiry = oldClient.PrevSubTokenExpiry
+		if client.ResetMode != oldClient.ResetMode {
+			client.LastReset = 0
+		}
+		// Only a client which stays disabled keeps the reason it was disabled for
+		client.DisabledReason = ""
+		if !client.Enable && !oldClient.Enable {
+			client.DisabledReason = oldClient.DisabledReason
+		}
+	} else {
+		client.LastReset = 0
+		client.DisabledReason = ""
+		client.FirstUse = 0
+		client.SubToken = common.NewSubToken()
+		client.PrevSubToken = ""
+		client.PrevSubTokenExpiry = 0
+	}
+
+	if client.ExpiryAfterUse < 0 {
+		return common.NewError("expiry after first use of ", client.Name, " can not be negative")
+	}
+	if client.IPLimit < 0 {
+		return common.NewError("address limit of ", client.Name, " can not be negative")
+	}
+	if client.UpLimit < 0 || client.DownLimit < 0 {
+		return common.NewError("speed limits of ", client.Name, " can not be negative")
+	}
+	if client.ExpiryAfterUse > 0 && client.Expiry > 0 {
+		return common.NewError("client ", client.Name, " can not have both an expiry date and an expiry after first use")
+	}
+
+	switch client.ResetMode {
+	case "":
+	case "daily":
+	case "weekly":
+		if client.ResetDay < 0 || client.ResetDay > 6 {
+			return common.NewError("weekly reset day of ", client.Name, " must be between 0 and 6")
+		}
+	case "monthly":
+		if client.ResetDay < 1 || client.ResetDay > 31 {
+			return common.NewError("monthly reset day of ", client.Name, " must be between 1 and 31")
+		}
+	case "days":
+		if client.ResetDays < 1 {
+			return common.NewError("reset interval of ", client.Name, " must be at least one day")
+		}
+	default:
+		return common.NewError("unknown reset mode: ", client.ResetMode)
+	}
+	// The schedule starts counting from when it is set
+	if client.ResetMode != "" && client.LastReset == 0 {
+		client.LastReset = time.Now().Unix()
+	}
+	return nil
+}
+
+// Replace the subscription token of a client. The previous token keeps working for the grace hours.
+func (s *ClientService) RotateSubToken(tx *gorm.DB, data json.RawMessage, reseller *model.User) error {
+	var rotate struct {
+		Id    uint `json:"id"`
+		Grace int  `json:"grace"`
+	}
+	err := json.Unmarshal(data, &rotate)
+	if err != nil {
+		return err
+	}
+	if rotate.Grace < 0 {
+		return common.NewError("grace period can not be negative")
+	}
+	var client model.Client
+	err = tx.Model(model.Client{}).Select("id, owner_id, sub_token").Where("id = ?", rotate.Id).First(&client).Error
+	if err != nil {
+		return err
+	}
+	if reseller != nil && client.OwnerId != reseller.Id {
+		return common.NewError("client not found")
+	}
+	updates := map[string]interface{}{
+		"sub_token":             common.NewSubToken(),
+		"prev_sub_token":        "",
+		"prev_sub_token_expiry": 0,
+	}
+	if rotate.Grace > 0 {
+		updates["prev_sub_token"] = client.SubToken
+		updates["prev_sub_token_expiry"] = time.Now().Unix() + int64(rotate.Grace)*3600
+	}
+	return tx.Model(model.Client{}).Where("id = ?", client.Id).Updates(updates).Error
+}
+
+func (s *ClientService) checkResellerQuota(tx *gorm.DB, reseller *model.User) error {
+	if reseller == nil {
+		return nil
+	}
+	var usage struct {
+		Count  int64
+		Volume int64
+	}
+	err := tx.Model(model.Client{}).Select("count(*) as count, coalesce(sum(volume), 0) as volume").Where("owner_id = ?", reseller.Id).Scan(&usage).Error
+	if err != nil {
+		return err
+	}
+	if reseller.MaxClients > 0 && usage.Count > int64(reseller.MaxClients) {
+		return common.NewErrorf("client count quota exceeded: %d/%d", usage.Count, reseller.MaxClients)
+	}
+	if reseller.MaxVolume > 0 && usage.Volume > reseller.MaxVolume {
+		return common.NewErrorf("volume quota exceeded: %d/%d", usage.Volume, reseller.MaxVolume)
+	}
+	return nil
 }
 
 func (s *ClientService) updateLinksWithFixedInbounds(tx *gorm.DB, clients []*model.Client, hostname string) error {
@@ -332,6 +571,7 @@ func (s *ClientService) DepleteClients() ([]uint, error) {
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			closeUserConnections(users...)
 		} else {
 			tx.Rollback()
 		}
@@ -356,12 +596,16 @@ func (s *ClientService) DepleteClients() ([]uint, error) {
 			Key:      "clients",
 			Action:   "disable",
 			Obj:      json.RawMessage("\"" + client.Name + "\""),
+			ObjId:    strconv.FormatUint(uint64(client.Id), 10),
 		})
 	}
 
 	// Save changes
 	if len(changes) > 0 {
-		err = tx.Model(model.Client{}).Where("enable = true AND ((volume >0 AND up+down > volume) OR (expiry > 0 AND expiry < ?))", now).Update("enable", false).Error
+		err = tx.Model(model.Client{}).Where("enable = true AND ((volume >0 AND up+down > volume) OR (expiry > 0 AND expiry < ?))", now).Updates(map[string]interface{}{
+			"enable":          false,
+			"disabled_reason": gorm.Expr("CASE WHEN expiry > 0 AND expiry < ? THEN 'expiry' ELSE 'volume' END", now),
+		}).Error
 		if err != nil {
 			return nil, err
 		}
@@ -369,6 +613,10 @@ func (s *ClientService) DepleteClients() ([]uint, error) {
 		if err != nil {
 			return nil, err
 		}
+		err = enqueueWebhooks(tx, changes...)
+		if err != nil {
+			return nil, err
+		}
 		LastUpdate = dt
 	}
 
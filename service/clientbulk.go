@@ -0,0 +1,244 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// Clients selected by a bulk operation. Set conditions are combined, All must be set to select every client.
+type ClientsFilter struct {
+	All     bool    `json:"all"`
+	Ids     []uint  `json:"ids"`
+	Group   *string `json:"group"`
+	Enable  *bool   `json:"enable"`
+	Inbound uint    `json:"inbound"`
+}
+
+// Operations: "extend" by Days, "addVolume" of Volume bytes, "resetTraffic", "enable", "disable",
+// "move" from inbound From to inbound To, and "delete"
+type ClientsBulk struct {
+	Filter ClientsFilter `json:"filter"`
+	Op     string        `json:"op"`
+	Days   int           `json:"days,omitempty"`
+	Volume int64         `json:"volume,omitempty"`
+	From   uint          `json:"from,omitempty"`
+	To     uint          `json:"to,omitempty"`
+}
+
+func (s *ClientService) findBulkClients(tx *gorm.DB, filter *ClientsFilter, reseller *model.User) ([]model.Client, error) {
+	query := tx.Model(model.Client{})
+	empty := true
+	if len(filter.Ids) > 0 {
+		query = query.Where("id in ?", filter.Ids)
+		empty = false
+	}
+	if filter.Group != nil {
+		query = query.Where("`group` = ?", *filter.Group)
+		empty = false
+	}
+	if filter.Enable != nil {
+		query = query.Where("enable = ?", *filter.Enable)
+		empty = false
+	}
+	if filter.Inbound > 0 {
+		query = query.Where("? IN (SELECT json_each.value FROM json_each(clients.inbounds))", filter.Inbound)
+		empty = false
+	}
+	if empty && !filter.All {
+		return nil, common.NewError("bulk operation without filter")
+	}
+	if reseller != nil {
+		query = query.Where("owner_id = ?", reseller.Id)
+	}
+	var clients []model.Client
+	err := query.Find(&clients).Error
+	return clients, err
+}
+
+func (b *ClientsBulk) validate(tx *gorm.DB, reseller *model.User) error {
+	switch b.Op {
+	case "extend":
+		if b.Days <= 0 {
+			return common.NewError("days to extend must be positive")
+		}
+	case "addVolume":
+		if b.Volume <= 0 {
+			return common.NewError("volume to add must be positive")
+		}
+	case "resetTraffic":
+		if reseller != nil {
+			return common.NewError("resellers can not reset traffic")
+		}
+	case "enable", "disable", "delete":
+	case "move":
+		if b.From == 0 || b.To == 0 || b.From == b.To {
+			return common.NewError("move needs two different inbounds")
+		}
+		var count int64
+		err := tx.Model(model.Inbound{}).Where("id = ?", b.To).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return common.NewErrorf("inbound %d not found", b.To)
+		}
+	default:
+		return common.NewError("unknown bulk operation: ", b.Op)
+	}
+	return nil
+}
+
+// Apply one operation to every client matching the filter. Returns the inbounds which need
+// a restart, the clients whose connections are dropped after the commit and a summary of the operation for the changes log.
+func (s *ClientService) Bulk(tx *gorm.DB, data json.RawMessage, hostname string, reseller *model.User) ([]uint, []string, json.RawMessage, error) {
+	var bulk ClientsBulk
+	err := json.Unmarshal(data, &bulk)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = bulk.validate(tx, reseller)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clients, err := s.findBulkClients(tx, &bulk.Filter, reseller)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(clients) == 0 {
+		return nil, nil, nil, common.NewError("no client matches the filter")
+	}
+
+	now := time.Now().Unix()
+	extension := int64(bulk.Days) * 86400
+	var inboundIds []uint
+	var closed []string
+	names := []string{}
+	for _, client := range clients {
+		var clientInbounds []uint
+		err = json.Unmarshal(client.Inbounds, &clientInbounds)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		updates := map[string]interface{}{}
+		reenable := false
+		switch bulk.Op {
+		case "extend":
+			// Unlimited clients stay unlimited
+			if client.Expiry > 0 {
+				updates["expiry"] = client.Expiry + extension
+				reenable = client.DisabledReason == "expiry" && client.Expiry+extension > now
+			} else if client.ExpiryAfterUse > 0 {
+				updates["expiry_after_use"] = client.ExpiryAfterUse + extension
+			}
+		case "addVolume":
+			if client.Volume > 0 {
+				updates["volume"] = client.Volume + bulk.Volume
+				reenable = client.DisabledReason == "volume" && client.Up+client.Down < client.Volume+bulk.Volume
+			}
+		case "resetTraffic":
+			err = tx.Create(&model.ClientUsage{
+				ClientId: client.Id,
+				Name:     client.Name,
+				Up:       client.Up,
+				Down:     client.Down,
+				Volume:   client.Volume,
+				From:     client.LastReset,
+				To:       now,
+			}).Error
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			updates["up"] = 0
+			updates["down"] = 0
+			updates["last_reset"] = now
+			reenable = client.DisabledReason == "volume" && (client.Expiry == 0 || client.Expiry > now)
+		case "enable":
+			if !client.Enable {
+				updates["enable"] = true
+				updates["disabled_reason"] = ""
+				inboundIds = common.UnionUintArray(inboundIds, clientInbounds)
+			}
+		case "disable":
+			if client.Enable {
+				updates["enable"] = false
+				updates["disabled_reason"] = ""
+				inboundIds = common.UnionUintArray(inboundIds, clientInbounds)
+				closed = append(closed, client.Name)
+			}
+		case "move":
+			moved := []uint{}
+			found := false
+			for _, id := range clientInbounds {
+				if id == bulk.From {
+					found = true
+				} else if id != bulk.To {
+					moved = append(moved, id)
+				}
+			}
+			if !found {
+				continue
+			}
+			client.Inbounds, err = json.Marshal(append(moved, bulk.To))
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			err = s.updateLinksWithFixedInbounds(tx, []*model.Client{&client}, hostname)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			updates["inbounds"] = client.Inbounds
+			updates["links"] = client.Links
+			inboundIds = common.UnionUintArray(inboundIds, []uint{bulk.From, bulk.To})
+		case "delete":
+			err = tx.Where("id = ?", client.Id).Delete(model.Client{}).Error
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			inboundIds = common.UnionUintArray(inboundIds, clientInbounds)
+			names = append(names, client.Name)
+			closed = append(closed, client.Name)
+			continue
+		}
+		if reenable && !client.Enable {
+			updates["enable"] = true
+			updates["disabled_reason"] = ""
+			inboundIds = common.UnionUintArray(inboundIds, clientInbounds)
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if reseller != nil && reseller.MaxExpiryDays > 0 {
+			maxExpiry := now + int64(reseller.MaxExpiryDays)*86400
+			if expiry, ok := updates["expiry"].(int64); ok && expiry > maxExpiry {
+				return nil, nil, nil, common.NewErrorf("expiry of %s exceeds the reseller limit of %d days", client.Name, reseller.MaxExpiryDays)
+			}
+			if expiry, ok := updates["expiry_after_use"].(int64); ok && expiry > int64(reseller.MaxExpiryDays)*86400 {
+				return nil, nil, nil, common.NewErrorf("expiry of %s exceeds the reseller limit of %d days", client.Name, reseller.MaxExpiryDays)
+			}
+		}
+		err = tx.Model(model.Client{}).Where("id = ?", client.Id).Updates(updates).Error
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		names = append(names, client.Name)
+	}
+	err = s.checkResellerQuota(tx, reseller)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	summary, err := json.Marshal(map[string]interface{}{
+		"bulk":    bulk,
+		"count":   len(names),
+		"clients": names,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return inboundIds, closed, summary, nil
+}
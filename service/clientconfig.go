@@ -0,0 +1,56 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/alireza0/s-ui/util/common"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Protocols whose user is identified by "username" instead of "name"
+var usernameProtocols = map[string]bool{"mixed": true, "socks": true, "http": true, "naive": true}
+
+func randomShadowsocksPassword(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Credentials of every protocol for a new client, the same set the panel generates
+func RandomClientConfig(name string) map[string]map[string]interface{} {
+	password := common.Random(10)
+	ssPassword16 := randomShadowsocksPassword(16)
+	ssPassword32 := randomShadowsocksPassword(32)
+	id := uuid.Must(uuid.NewV4()).String()
+	config := map[string]map[string]interface{}{
+		"mixed":         {"password": password},
+		"socks":         {"password": password},
+		"http":          {"password": password},
+		"shadowsocks":   {"password": ssPassword32},
+		"shadowsocks16": {"password": ssPassword16},
+		"shadowtls":     {"password": ssPassword32},
+		"vmess":         {"uuid": id, "alterId": 0},
+		"vless":         {"uuid": id, "flow": "xtls-rprx-vision"},
+		"anytls":        {"password": password},
+		"trojan":        {"password": password},
+		"naive":         {"password": password},
+		"hysteria":      {"auth_str": password},
+		"tuic":          {"uuid": id, "password": password},
+		"hysteria2":     {"password": password},
+	}
+	SetClientConfigName(config, name)
+	return config
+}
+
+// Point the user name of every protocol to the client name
+func SetClientConfigName(config map[string]map[string]interface{}, name string) {
+	for protocol, fields := range config {
+		if usernameProtocols[protocol] {
+			fields["username"] = name
+		} else {
+			fields["name"] = name
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// Columns of the client CSV. Inbounds are tags separated by "|",
+// credentials are "<protocol>.<field>" of the client config.
+var (
+	csvBaseColumns       = []string{"name", "enable", "group", "desc", "volume", "expiry", "up", "down", "inbounds"}
+	csvCredentialColumns = []string{
+		"vless.uuid", "vless.flow", "vmess.uuid", "trojan.password", "shadowsocks.password", "shadowsocks16.password",
+		"shadowtls.password", "hysteria.auth_str", "hysteria2.password", "tuic.uuid", "tuic.password",
+		"anytls.password", "naive.password", "mixed.password", "socks.password", "http.password",
+	}
+)
+
+type CsvRowResult struct {
+	Line   int      `json:"line"`
+	Name   string   `json:"name"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+type CsvImportResult struct {
+	DryRun   bool           `json:"dryRun"`
+	Total    int            `json:"total"`
+	Invalid  int            `json:"invalid"`
+	Imported int            `json:"imported"`
+	Rows     []CsvRowResult `json:"rows"`
+}
+
+func inboundTags(tx *gorm.DB) (map[uint]string, error) {
+	var inbounds []model.Inbound
+	err := tx.Model(model.Inbound{}).Select("id, tag").Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[uint]string, len(inbounds))
+	for _, inbound := range inbounds {
+		tags[inbound.Id] = inbound.Tag
+	}
+	return tags, nil
+}
+
+// Write the clients matching the filter as CSV
+func (s *ClientService) ExportCsv(filter *ClientsFilter, reseller *model.User) ([]byte, error) {
+	db := database.GetDB()
+	clients, err := s.findBulkClients(db, filter, reseller)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := inboundTags(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(append(append([]string{}, csvBaseColumns...), csvCredentialColumns...))
+	for _, client := range clients {
+		var inboundIds []uint
+		json.Unmarshal(client.Inbounds, &inboundIds)
+		var clientTags []string
+		for _, id := range inboundIds {
+			if tag, ok := tags[id]; ok {
+				clientTags = append(clientTags, tag)
+			}
+		}
+		var config map[string]map[string]interface{}
+		json.Unmarshal(client.Config, &config)
+
+		record := []string{
+			client.Name,
+			strconv.FormatBool(client.Enable),
+			client.Group,
+			client.Desc,
+			strconv.FormatInt(client.Volume, 10),
+			strconv.FormatInt(client.Expiry, 10),
+			strconv.FormatInt(client.Up, 10),
+			strconv.FormatInt(client.Down, 10),
+			strings.Join(clientTags, "|"),
+		}
+		for _, column := range csvCredentialColumns {
+			protocol, field, _ := strings.Cut(column, ".")
+			value := ""
+			if v, ok := config[protocol][field]; ok && v != nil {
+				value = fmt.Sprint(v)
+			}
+			record = append(record, value)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Unix seconds, RFC 3339 or a plain date. Empty means no expiry.
+func parseCsvExpiry(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, common.NewError("invalid expiry: ", value)
+	}
+	return t.Unix(), nil
+}
+
+// Parse and validate every row. Rows with errors are reported and not returned.
+func (s *ClientService) parseCsv(tx *gorm.DB, data []byte) ([]*model.Client, *CsvImportResult, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, common.NewError("invalid csv header: ", err)
+	}
+	known := map[string]bool{}
+	for _, column := range append(append([]string{}, csvBaseColumns...), csvCredentialColumns...) {
+		known[column] = true
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if !known[column] {
+			return nil, nil, common.NewError("unknown csv column: ", column)
+		}
+		columns[column] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, nil, common.NewError("csv has no name column")
+	}
+
+	tags, err := inboundTags(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	tagIds := make(map[string]uint, len(tags))
+	for id, tag := range tags {
+		tagIds[tag] = id
+	}
+	var existing []string
+	err = tx.Model(model.Client{}).Pluck("name", &existing).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		seen[name] = true
+	}
+
+	result := &CsvImportResult{Rows: []CsvRowResult{}}
+	var clients []*model.Client
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		row := CsvRowResult{Line: line}
+		if err != nil {
+			row.Errors = append(row.Errors, err.Error())
+			result.Rows = append(result.Rows, row)
+			result.Total++
+			result.Invalid++
+			continue
+		}
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		fail := func(msg ...interface{}) {
+			row.Errors = append(row.Errors, strings.TrimSpace(fmt.Sprint(msg...)))
+		}
+		parseInt := func(column string) int64 {
+			value := get(column)
+			if value == "" {
+				return 0
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				fail("invalid ", column, ": ", value)
+			}
+			return n
+		}
+
+		client := &model.Client{
+			Name:   get("name"),
+			Enable: true,
+			Group:  get("group"),
+			Desc:   get("desc"),
+			Volume: parseInt("volume"),
+			Up:     parseInt("up"),
+			Down:   parseInt("down"),
+			Links:  json.RawMessage("[]"),
+		}
+		row.Name = client.Name
+		if client.Name == "" {
+			fail("name is empty")
+		} else if seen[client.Name] {
+			fail("client ", client.Name, " already exists")
+		}
+		seen[client.Name] = true
+		if value := get("enable"); value != "" {
+			client.Enable, err = strconv.ParseBool(value)
+			if err != nil {
+				fail("invalid enable: ", value)
+			}
+		}
+		client.Expiry, err = parseCsvExpiry(get("expiry"))
+		if err != nil {
+			fail(err)
+		}
+
+		inboundIds := []uint{}
+		if value := get("inbounds"); value != "" {
+			for _, tag := range strings.Split(value, "|") {
+				tag = strings.TrimSpace(tag)
+				id, ok := tagIds[tag]
+				if !ok {
+					fail("inbound not found: ", tag)
+					continue
+				}
+				inboundIds = append(inboundIds, id)
+			}
+		}
+		sort.Slice(inboundIds, func(i, j int) bool { return inboundIds[i] < inboundIds[j] })
+		client.Inbounds, _ = json.Marshal(inboundIds)
+
+		config := RandomClientConfig(client.Name)
+		for _, column := range csvCredentialColumns {
+			if value := get(column); value != "" {
+				protocol, field, _ := strings.Cut(column, ".")
+				config[protocol][field] = value
+			}
+		}
+		client.Config, _ = json.Marshal(config)
+
+		result.Rows = append(result.Rows, row)
+		result.Total++
+		if len(row.Errors) > 0 {
+			result.Invalid++
+			continue
+		}
+		clients = append(clients, client)
+	}
+	return clients, result, nil
+}
+
+// Import clients from CSV in one transaction. Nothing is saved if any row is invalid or on a dry run.
+func (s *ConfigService) ImportClientsCsv(data []byte, dryRun bool, loginUser string, hostname string) (*CsvImportResult, error) {
+	reseller, err := s.UserService.GetReseller(loginUser)
+	if err != nil {
+		return nil, err
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	clients, result, err := s.ClientService.parseCsv(tx, data)
+	if err != nil {
+		return nil, err
+	}
+	result.DryRun = dryRun
+	if result.Invalid > 0 || len(clients) == 0 {
+		return result, nil
+	}
+
+	// addbulk expects clients which share their inbounds
+	groups := map[string][]*model.Client{}
+	var keys []string
+	for _, client := range clients {
+		key := string(client.Inbounds)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], client)
+	}
+	var inboundIds []uint
+	names := make([]string, 0, len(clients))
+	for _, key := range keys {
+		groupJson, err := json.Marshal(groups[key])
+		if err != nil {
+			return nil, err
+		}
+		ids, _, err := s.ClientService.Save(tx, "addbulk", groupJson, hostname, reseller)
+		if err != nil {
+			return nil, err
+		}
+		inboundIds = common.UnionUintArray(inboundIds, ids)
+		for _, client := range groups[key] {
+			names = append(names, client.Name)
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	if len(inboundIds) > 0 {
+		err = s.InboundService.RestartInbounds(tx, inboundIds)
+		if err != nil {
+			return nil, common.NewErrorf("failed to update users for inbounds: %v", err)
+		}
+	}
+	obj, err := json.Marshal(map[string]interface{}{
+		"count":   len(names),
+		"clients": names,
+	})
+	if err != nil {
+		return nil, err
+	}
+	change := model.Changes{
+		DateTime: time.Now().Unix(),
+		Actor:    loginUser,
+		Key:      "clients",
+		Action:   "import",
+		Obj:      obj,
+	}
+	err = tx.Create(&change).Error
+	if err != nil {
+		return nil, err
+	}
+	err = enqueueWebhooks(tx, change)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+	result.Imported = len(names)
+	LastUpdate = time.Now().Unix()
+	s.applyClientLimits()
+	return result, nil
+}
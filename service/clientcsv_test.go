@@ -0,0 +1,126 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/database/model"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// A private in-memory database with the tables of the given models
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would open another empty database
+	sqlDb.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDb.Close() })
+	err = db.AutoMigrate(models...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestParseCsvExpiry(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1700000000", 1700000000, false},
+		{"2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix(), false},
+		{"2024-01-02T03:04:05+03:30", time.Date(2024, 1, 1, 23, 34, 5, 0, time.UTC).Unix(), false},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC).Unix(), false},
+		{"02/01/2024", 0, true},
+		{"tomorrow", 0, true},
+	}
+	for _, c := range cases {
+		got, err := parseCsvExpiry(c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%q: expected error %v, got %v", c.value, c.wantErr, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q: expected %d, got %d", c.value, c.want, got)
+		}
+	}
+}
+
+func TestParseCsv(t *testing.T) {
+	db := newTestDB(t, &model.Tls{}, &model.Inbound{}, &model.Client{})
+	db.Create(&model.Inbound{Id: 1, Type: "vless", Tag: "in1"})
+	db.Create(&model.Inbound{Id: 2, Type: "vmess", Tag: "in2"})
+	db.Create(&model.Client{Name: "old", Config: json.RawMessage("{}"), Inbounds: json.RawMessage("[]"), Links: json.RawMessage("[]")})
+
+	cases := []struct {
+		csv     string
+		wantErr bool
+		clients []string
+		errors  []int // errors of every row
+	}{
+		{"name,color\nalice", true, nil, nil},
+		{"enable,volume\ntrue,1", true, nil, nil},
+		{"", true, nil, nil},
+		{"\ufeffname, enable\nalice,true\nbob,false", false, []string{"alice", "bob"}, []int{0, 0}},
+		{"name,volume,up,expiry\nalice,-1,x,never", false, nil, []int{3}},
+		{"name,enable,inbounds\nold,true,\n,maybe,in1\nbob,,in1|in3", false, nil, []int{1, 2, 1}},
+		{"name\nalice\nalice\n\"bob", false, []string{"alice"}, []int{0, 1, 1}},
+	}
+	for i, c := range cases {
+		clients, result, err := (&ClientService{}).parseCsv(db, []byte(c.csv))
+		if (err != nil) != c.wantErr {
+			t.Errorf("case %d: expected error %v, got %v", i, c.wantErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var names []string
+		for _, client := range clients {
+			names = append(names, client.Name)
+		}
+		if !reflect.DeepEqual(names, c.clients) {
+			t.Errorf("case %d: expected clients %v, got %v", i, c.clients, names)
+		}
+		var errors []int
+		invalid := 0
+		for _, row := range result.Rows {
+			errors = append(errors, len(row.Errors))
+			if len(row.Errors) > 0 {
+				invalid++
+			}
+		}
+		if !reflect.DeepEqual(errors, c.errors) || result.Total != len(c.errors) || result.Invalid != invalid {
+			t.Errorf("case %d: expected errors %v, got %+v", i, c.errors, result)
+		}
+	}
+
+	// Values of a valid row end up in the client
+	csv := "name,enable,group,volume,expiry,inbounds,vless.uuid\ncarol,false,vip,1000,1700000000,in2|in1,2f8b4c1e-0000-4000-8000-000000000000"
+	clients, _, err := (&ClientService{}).parseCsv(db, []byte(csv))
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("expected one client, got %v, %v", clients, err)
+	}
+	client := clients[0]
+	if client.Enable || client.Group != "vip" || client.Volume != 1000 || client.Expiry != 1700000000 || string(client.Inbounds) != "[1,2]" {
+		t.Errorf("unexpected client %+v", client)
+	}
+	var config map[string]map[string]interface{}
+	json.Unmarshal(client.Config, &config)
+	if config["vless"]["uuid"] != "2f8b4c1e-0000-4000-8000-000000000000" || config["vmess"]["uuid"] == nil {
+		t.Errorf("expected the given vless and a random vmess uuid, got %v", config)
+	}
+}
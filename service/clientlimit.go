@@ -0,0 +1,85 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/alireza0/s-ui/core"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"gorm.io/gorm"
+)
+
+// Push the address and speed limits of clients to the trackers of the running core
+func (s *ConfigService) applyClientLimits() {
+	if !corePtr.IsRunning() {
+		return
+	}
+	var clients []model.Client
+	err := database.GetDB().Model(model.Client{}).Select("name, ip_limit, up_limit, down_limit").
+		Where("ip_limit > 0 OR up_limit > 0 OR down_limit > 0").Find(&clients).Error
+	if err != nil {
+		logger.Warning("unable to load client limits: ", err)
+		return
+	}
+	limits := map[string]int{}
+	upLimits := map[string]int64{}
+	downLimits := map[string]int64{}
+	for _, client := range clients {
+		if client.IPLimit > 0 {
+			limits[client.Name] = client.IPLimit
+		}
+		if client.UpLimit > 0 {
+			upLimits[client.Name] = client.UpLimit
+		}
+		if client.DownLimit > 0 {
+			downLimits[client.Name] = client.DownLimit
+		}
+	}
+	corePtr.GetInstance().StatsTracker().SetRateLimits(upLimits, downLimits)
+
+	window, err := s.SettingService.GetIPLimitWindow()
+	if err != nil || window <= 0 {
+		window = 300
+	}
+	mode, err := s.SettingService.GetIPLimitMode()
+	if err != nil {
+		mode = defaultValueMap["ipLimitMode"]
+	}
+	corePtr.GetInstance().ConnTracker().SetIPLimits(limits, time.Duration(window)*time.Second, mode)
+}
+
+// Record address limit violations in the change log, one row per client and address of each run
+func (s *StatsService) saveIPViolations(tx *gorm.DB, violations []core.IPViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	changes := make([]model.Changes, 0, len(violations))
+	for _, violation := range violations {
+		obj, err := json.Marshal(violation)
+		if err != nil {
+			return err
+		}
+		var id uint
+		err = tx.Model(model.Client{}).Where("name = ?", violation.User).Select("id").Scan(&id).Error
+		if err != nil {
+			return err
+		}
+		changes = append(changes, model.Changes{
+			DateTime: violation.DateTime,
+			Actor:    "ConnTracker",
+			Key:      "clients",
+			Action:   "ipLimit",
+			Obj:      obj,
+			ObjId:    strconv.FormatUint(uint64(id), 10),
+		})
+	}
+	err := tx.Create(&changes).Error
+	if err != nil {
+		return err
+	}
+	return enqueueWebhooks(tx, changes...)
+}
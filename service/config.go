@@ -10,6 +10,8 @@ import (
 	"github.com/alireza0/s-ui/database/model"
 	"github.com/alireza0/s-ui/logger"
 	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
 )
 
 var (
@@ -18,6 +20,7 @@ var (
 )
 
 type ConfigService struct {
+	UserService
 	ClientService
 	TlsService
 	SettingService
@@ -95,6 +98,7 @@ func (s *ConfigService) StartCore(defaultConfig string) error {
 		return err
 	}
 	logger.Info("sing-box started")
+	s.applyClientLimits()
 	return nil
 }
 
@@ -103,53 +107,144 @@ func (s *ConfigService) RestartCore() error {
 	if err != nil {
 		return err
 	}
-	return s.StartCore("")
+	err = s.StartCore("")
+	if err != nil {
+		return err
+	}
+	s.notifyRestart(database.GetDB())
+	return nil
 }
 
-func (s *ConfigService) restartCoreWithConfig(config json.RawMessage) error {
-	err := s.StopCore()
+// Restart the core with a config saved on tx. Everything is written on tx, a second transaction
+// would wait for the lock tx holds. The returned function gives the flushed traffic back if tx is rolled back.
+func (s *ConfigService) restartCoreWithConfig(tx *gorm.DB, config json.RawMessage) (func(), error) {
+	restore, err := s.stopCore(tx)
 	if err != nil {
-		return err
+		return restore, err
+	}
+	err = s.StartCore(string(config))
+	if err != nil {
+		return restore, err
+	}
+	s.notifyRestart(tx)
+	return restore, nil
+}
+
+func (s *ConfigService) notifyRestart(tx *gorm.DB) {
+	err := enqueueWebhooks(tx, model.Changes{
+		DateTime: time.Now().Unix(),
+		Actor:    "core",
+		Key:      "core",
+		Action:   "restart",
+	})
+	if err != nil {
+		logger.Warning("unable to queue core restart webhooks: ", err)
 	}
-	return s.StartCore(string(config))
 }
 
+// Stop the core and save the traffic counted since the last StatsJob run, also when closing failed
 func (s *ConfigService) StopCore() error {
+	db := database.GetDB()
+	tx := db.Begin()
+	restore, err := s.stopCore(tx)
+	if commitErr := tx.Commit().Error; commitErr != nil {
+		logger.Warning("unable to save traffic of the stopped core: ", commitErr)
+		restore()
+	}
+	return err
+}
+
+func (s *ConfigService) stopCore(tx *gorm.DB) (func(), error) {
+	var instance *core.Box
+	if corePtr.IsRunning() {
+		instance = corePtr.GetInstance()
+	}
 	err := corePtr.Stop()
+	statsService := StatsService{}
+	restore, flushErr := statsService.FlushStats(tx, instance)
+	if flushErr != nil {
+		logger.Warning("unable to save traffic of the stopped core: ", flushErr)
+	}
 	if err != nil {
-		return err
+		return restore, err
 	}
 	logger.Info("sing-box stopped")
-	return nil
+	return restore, nil
 }
 
 func (s *ConfigService) Save(obj string, act string, data json.RawMessage, initUsers string, loginUser string, hostname string) ([]string, error) {
+	objs, _, err := s.save(obj, act, data, initUsers, loginUser, hostname)
+	return objs, err
+}
+
+// Save a new object and return its id
+func (s *ConfigService) SaveNew(obj string, data json.RawMessage, initUsers string, loginUser string, hostname string) (string, error) {
+	_, objId, err := s.save(obj, "new", data, initUsers, loginUser, hostname)
+	return objId, err
+}
+
+// Save a change and return the objects to reload with the id of the changed object
+func (s *ConfigService) save(obj string, act string, data json.RawMessage, initUsers string, loginUser string, hostname string) ([]string, string, error) {
 	var err error
 	var objs []string = []string{obj}
+	var closed []string
+	restoreStats := func() {}
 
 	db := database.GetDB()
 	tx := db.Begin()
 	defer func() {
 		if err == nil {
-			tx.Commit()
+			if tx.Commit().Error != nil {
+				restoreStats()
+			}
+			// Connections are only dropped once the change is committed
+			closeUserConnections(closed...)
 			// Try to start core if it is not running
 			if !corePtr.IsRunning() {
 				s.StartCore("")
+			} else if obj == "clients" || obj == "settings" {
+				s.applyClientLimits()
 			}
 		} else {
 			tx.Rollback()
+			restoreStats()
 		}
 	}()
 
+	var before json.RawMessage
+	var objId string
+	if act != "new" {
+		before, objId, err = s.auditSnapshot(tx, obj, act, data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
 	switch obj {
 	case "clients":
 		var inboundIds []uint
-		inboundIds, err = s.ClientService.Save(tx, act, data, hostname)
+		var reseller *model.User
+		reseller, err = s.UserService.GetReseller(loginUser)
+		if err != nil {
+			return nil, "", err
+		}
+		if act == "bulk" {
+			// The summary of a bulk operation is logged instead of the request
+			var summary json.RawMessage
+			inboundIds, closed, summary, err = s.ClientService.Bulk(tx, data, hostname, reseller)
+			if err == nil {
+				data = summary
+			}
+		} else if act == "rotateSubToken" {
+			err = s.ClientService.RotateSubToken(tx, data, reseller)
+		} else {
+			inboundIds, closed, err = s.ClientService.Save(tx, act, data, hostname, reseller)
+		}
 		if err == nil && len(inboundIds) > 0 {
 			objs = append(objs, "inbounds")
 			err = s.InboundService.RestartInbounds(tx, inboundIds)
 			if err != nil {
-				return nil, common.NewErrorf("failed to update users for inbounds: %v", err)
+				return nil, "", common.NewErrorf("failed to update users for inbounds: %v", err)
 			}
 		}
 	case "tls":
@@ -167,67 +262,212 @@ func (s *ConfigService) Save(obj string, act string, data json.RawMessage, initU
 	case "config":
 		err = s.SettingService.SaveConfig(tx, data)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-		err = s.restartCoreWithConfig(data)
+		restoreStats, err = s.restartCoreWithConfig(tx, data)
 	case "settings":
 		err = s.SettingService.Save(tx, data)
 	default:
-		return nil, common.NewError("unknown object: ", obj)
+		return nil, "", common.NewError("unknown object: ", obj)
 	}
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	var after json.RawMessage
+	if act != "del" {
+		var afterId string
+		after, afterId, err = s.auditSnapshot(tx, obj, act, data)
+		if err != nil {
+			return nil, "", err
+		}
+		if objId == "" {
+			objId = afterId
+		}
 	}
 
 	dt := time.Now().Unix()
-	err = tx.Create(&model.Changes{
+	change := model.Changes{
 		DateTime: dt,
 		Actor:    loginUser,
 		Key:      obj,
 		Action:   act,
 		Obj:      data,
-	}).Error
+	}
+	if obj == "settings" {
+		change.Obj, err = redactSettingsJson(data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	err = s.auditChange(&change, before, after, objId)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	err = tx.Create(&change).Error
+	if err != nil {
+		return nil, "", err
+	}
+	err = enqueueWebhooks(tx, change)
+	if err != nil {
+		return nil, "", err
 	}
 
 	LastUpdate = time.Now().Unix()
 
-	return objs, nil
+	return objs, objId, nil
+}
+
+func (s *ConfigService) AddChange(actor string, key string, action string, obj interface{}) error {
+	objJson, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	change := model.Changes{
+		DateTime: time.Now().Unix(),
+		Actor:    actor,
+		Key:      key,
+		Action:   action,
+		Obj:      objJson,
+	}
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&change).Error
+		if err != nil {
+			return err
+		}
+		return enqueueWebhooks(tx, change)
+	})
 }
 
 func (s *ConfigService) CheckChanges(lu string) (bool, error) {
 	if lu == "" {
 		return true, nil
 	}
+	intLu, err := strconv.ParseInt(lu, 10, 64)
+	if err != nil {
+		return false, err
+	}
 	if LastUpdate == 0 {
 		db := database.GetDB()
 		var count int64
-		err := db.Model(model.Changes{}).Where("date_time > " + lu).Count(&count).Error
+		err := db.Model(model.Changes{}).Where("date_time > ?", intLu).Count(&count).Error
 		if err == nil {
 			LastUpdate = time.Now().Unix()
 		}
 		return count > 0, err
 	} else {
-		intLu, err := strconv.ParseInt(lu, 10, 64)
-		return LastUpdate > intLu, err
+		return LastUpdate > intLu, nil
 	}
 }
 
 func (s *ConfigService) GetChanges(actor string, chngKey string, count string) []model.Changes {
 	c, _ := strconv.Atoi(count)
-	whereString := "`id`>0"
-	if len(actor) > 0 {
-		whereString += " and `actor`='" + actor + "'"
-	}
-	if len(chngKey) > 0 {
-		whereString += " and `key`='" + chngKey + "'"
+	chngs, _, err := s.QueryChanges(ChangesFilter{Actor: actor, Key: chngKey, Limit: c})
+	if err != nil {
+		logger.Warning(err)
 	}
+	return chngs
+}
+
+// Filtered and paged change log, newest first, with the total number of matches
+func (s *ConfigService) QueryChanges(filter ChangesFilter) ([]model.Changes, int64, error) {
 	db := database.GetDB()
+	query := db.Model(model.Changes{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Key != "" {
+		query = query.Where("key = ?", filter.Key)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ObjId != "" {
+		query = query.Where("obj_id = ?", filter.ObjId)
+	}
+	if filter.From > 0 {
+		query = query.Where("date_time >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		query = query.Where("date_time <= ?", filter.To)
+	}
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = -1
+	}
 	var chngs []model.Changes
-	err := db.Model(model.Changes{}).Where(whereString).Order("`id` desc").Limit(c).Scan(&chngs).Error
+	err = query.Order("id desc").Offset(filter.Offset).Limit(filter.Limit).Scan(&chngs).Error
+	return chngs, total, err
+}
+
+// Delete a subscription with its outbounds like other saves, so the core, change log and webhooks follow
+func (s *ConfigService) DeleteSubscription(id uint, loginUser string) error {
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var subscription model.Subscription
+	err = tx.First(&subscription, id).Error
 	if err != nil {
-		logger.Warning(err)
+		return err
 	}
-	return chngs
+	var tags []string
+	err = tx.Model(model.Outbound{}).Where("subscription_id = ?", id).Pluck("tag", &tags).Error
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		var data json.RawMessage
+		data, err = json.Marshal(tag)
+		if err != nil {
+			return err
+		}
+		err = s.OutboundService.Save(tx, "del", data)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Delete(&model.Subscription{}, id).Error
+	if err != nil {
+		return err
+	}
+
+	var obj json.RawMessage
+	obj, err = json.Marshal(map[string]interface{}{
+		"subscription": subscription,
+		"outbounds":    tags,
+	})
+	if err != nil {
+		return err
+	}
+	change := model.Changes{
+		DateTime: time.Now().Unix(),
+		Actor:    loginUser,
+		Key:      "subscriptions",
+		Action:   "del",
+		Obj:      obj,
+		ObjId:    strconv.FormatUint(uint64(id), 10),
+	}
+	err = tx.Create(&change).Error
+	if err != nil {
+		return err
+	}
+	err = enqueueWebhooks(tx, change)
+	if err != nil {
+		return err
+	}
+	LastUpdate = time.Now().Unix()
+	return nil
 }
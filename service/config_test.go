@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/core"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+
+	"gorm.io/gorm"
+)
+
+func TestFlushStatsOnSaveTransaction(t *testing.T) {
+	// A file database locks like the panel's, an in-memory one has a single connection
+	err := database.OpenDB(filepath.Join(t.TempDir(), "s-ui.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.GetDB()
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDb.Close() })
+	err = db.AutoMigrate(&model.Setting{}, &model.Client{}, &model.Stats{}, &model.Changes{}, &model.Webhook{}, &model.WebhookDelivery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&model.Client{Name: "alice", Config: json.RawMessage("{}"), Inbounds: json.RawMessage("[]"), Links: json.RawMessage("[]")})
+	db.Create(&model.Webhook{Url: "http://127.0.0.1/hook", Events: json.RawMessage(`["core.*"]`), Enabled: true})
+
+	tracker := core.NewStatsTracker()
+	connTracker := core.NewConnTracker()
+	tracker.Restore(&[]model.Stats{
+		{Resource: "user", Tag: "alice", Direction: true, Traffic: 100},
+		{Resource: "inbound", Tag: "in", Direction: false, Traffic: 50},
+	})
+
+	// Saving a config holds the write lock until the restarted core is running
+	flush := func() (*gorm.DB, func()) {
+		t.Helper()
+		tx := db.Begin()
+		if err := tx.Create(&model.Setting{Key: "config", Value: "{}"}).Error; err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		restore, err := (&StatsService{}).flushStats(tx, tracker, connTracker)
+		if err != nil {
+			t.Fatalf("expected the traffic to be saved on the open transaction, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected no wait for the lock, took %v", elapsed)
+		}
+		(&ConfigService{}).notifyRestart(tx)
+		return tx, restore
+	}
+
+	// A rolled back save gives the traffic back to the trackers
+	tx, restore := flush()
+	tx.Rollback()
+	restore()
+	var count int64
+	db.Model(model.Stats{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no stats after a rollback, got %d", count)
+	}
+
+	tx, _ = flush()
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+	var client model.Client
+	db.Where("name = ?", "alice").First(&client)
+	if client.Up != 100 {
+		t.Errorf("expected 100 bytes up of alice, got %d", client.Up)
+	}
+	db.Model(model.Stats{}).Count(&count)
+	if count != 4 {
+		t.Errorf("expected 4 stats rows, got %d", count)
+	}
+	db.Model(model.WebhookDelivery{}).Where("event = ?", "core.restart").Count(&count)
+	if count != 1 {
+		t.Errorf("expected one queued core.restart delivery, got %d", count)
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"github.com/alireza0/s-ui/core"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+type ConnectionService struct{}
+
+// Live connections of the running core. A non-nil owned set limits them to those users.
+func (s *ConnectionService) GetConnections(owned map[string]bool) ([]core.Connection, error) {
+	if !corePtr.IsRunning() {
+		return []core.Connection{}, nil
+	}
+	connections := corePtr.GetInstance().ConnTracker().Connections()
+	if owned == nil {
+		return connections, nil
+	}
+	result := []core.Connection{}
+	for _, connection := range connections {
+		if owned[connection.User] {
+			result = append(result, connection)
+		}
+	}
+	return result, nil
+}
+
+// Close one connection by id, or all connections of a user or an outbound. Returns how many were closed.
+func (s *ConnectionService) Close(id string, user string, outbound string, owned map[string]bool) (int, error) {
+	if !corePtr.IsRunning() {
+		return 0, common.NewError("core is not running")
+	}
+	tracker := corePtr.GetInstance().ConnTracker()
+	switch {
+	case id != "":
+		if owned != nil {
+			allowed := false
+			for _, connection := range tracker.Connections() {
+				if connection.Id == id {
+					allowed = owned[connection.User]
+					break
+				}
+			}
+			if !allowed {
+				return 0, common.NewError("connection not found")
+			}
+		}
+		return tracker.CloseConnById(id), nil
+	case user != "":
+		if owned != nil && !owned[user] {
+			return 0, common.NewError("client not found")
+		}
+		return tracker.CloseConnByUser(user), nil
+	case outbound != "":
+		if owned != nil {
+			return 0, common.NewError("closing connections of an outbound is not allowed")
+		}
+		return tracker.CloseConnByOutbound(outbound), nil
+	}
+	return 0, common.NewError("no connection, user or outbound given")
+}
+
+// Drop the open connections of clients which were disabled or deleted
+func closeUserConnections(users ...string) {
+	if corePtr == nil || !corePtr.IsRunning() {
+		return
+	}
+	tracker := corePtr.GetInstance().ConnTracker()
+	for _, user := range users {
+		if user == "" {
+			continue
+		}
+		if closed := tracker.CloseConnByUser(user); closed > 0 {
+			logger.Debug("closed ", closed, " connections of client ", user)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type marzbanUser struct {
+	Id                     uint
+	Username               string
+	Status                 string
+	UsedTraffic            int64
+	DataLimit              sql.NullInt64
+	DataLimitResetStrategy string
+	Expire                 sql.NullString // unix seconds or a date time, depending on the version
+	Note                   sql.NullString
+	OnHoldExpireDuration   sql.NullInt64
+}
+
+type marzbanProxy struct {
+	Id       uint
+	UserId   uint
+	Type     string
+	Settings string
+}
+
+type marzbanExclude struct {
+	ProxyId    uint
+	InboundTag string
+}
+
+func (p *panelImport) readMarzban(src *gorm.DB) error {
+	var users []marzbanUser
+	err := src.Table("users").Order("id").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	var proxies []marzbanProxy
+	err = src.Table("proxies").Order("id").Find(&proxies).Error
+	if err != nil {
+		return err
+	}
+	var tags []string
+	if src.Migrator().HasTable("inbounds") {
+		err = src.Table("inbounds").Order("id").Pluck("tag", &tags).Error
+		if err != nil {
+			return err
+		}
+	}
+	excluded := map[uint]map[string]bool{}
+	if src.Migrator().HasTable("exclude_inbounds_association") {
+		var excludes []marzbanExclude
+		err = src.Table("exclude_inbounds_association").Find(&excludes).Error
+		if err != nil {
+			return err
+		}
+		for _, exclude := range excludes {
+			if excluded[exclude.ProxyId] == nil {
+				excluded[exclude.ProxyId] = map[string]bool{}
+			}
+			excluded[exclude.ProxyId][exclude.InboundTag] = true
+		}
+	}
+	p.report.issue("panel", "", "Marzban keeps its inbounds in the xray config, clients only join existing inbounds with the same tag and protocol")
+	p.report.issue("panel", "", "Marzban does not split upload and download, the used traffic is imported as download")
+
+	clients := map[uint]*importedClient{}
+	for _, user := range users {
+		c, created := p.client(user.Username)
+		if !created {
+			p.report.issue("client", user.Username, "duplicate username")
+			continue
+		}
+		clients[user.Id] = c
+		client := &c.client
+		client.Down = user.UsedTraffic
+		client.Volume = user.DataLimit.Int64
+		client.Desc = user.Note.String
+		switch user.Status {
+		case "disabled":
+			client.Enable = false
+		case "limited":
+			client.Enable = false
+			client.DisabledReason = "volume"
+		case "expired":
+			client.Enable = false
+			client.DisabledReason = "expiry"
+		}
+		if user.Status == "on_hold" && user.OnHoldExpireDuration.Int64 > 0 {
+			client.ExpiryAfterUse = user.OnHoldExpireDuration.Int64
+		} else if user.Expire.Valid {
+			client.Expiry, err = marzbanTime(user.Expire.String)
+			if err != nil {
+				p.report.issue("client", user.Username, "invalid expiry: ", user.Expire.String)
+			}
+		}
+		switch user.DataLimitResetStrategy {
+		case "", "no_reset":
+		case "day":
+			client.ResetMode = "daily"
+		case "week":
+			client.ResetMode, client.ResetDays = "days", 7
+		case "month":
+			client.ResetMode, client.ResetDays = "days", 30
+		case "year":
+			client.ResetMode, client.ResetDays = "days", 365
+		default:
+			p.report.issue("client", user.Username, "reset strategy ", user.DataLimitResetStrategy, " is not supported")
+		}
+	}
+
+	for _, proxy := range proxies {
+		c, ok := clients[proxy.UserId]
+		if !ok {
+			continue
+		}
+		var settings struct {
+			Id       string `json:"id"`
+			Flow     string `json:"flow"`
+			Password string `json:"password"`
+			Method   string `json:"method"`
+		}
+		err = json.Unmarshal([]byte(proxy.Settings), &settings)
+		if err != nil {
+			p.report.issue("client", c.client.Name, "invalid ", proxy.Type, " settings: ", err)
+			continue
+		}
+		protocol := strings.ToLower(proxy.Type)
+		switch protocol {
+		case "vless":
+			p.setCredentials(c, map[string]interface{}{"uuid": settings.Id, "flow": settings.Flow}, "vless")
+		case "vmess":
+			p.setCredentials(c, map[string]interface{}{"uuid": settings.Id}, "vmess")
+		case "trojan":
+			p.setCredentials(c, map[string]interface{}{"password": settings.Password}, "trojan")
+		case "shadowsocks":
+			p.setCredentials(c, map[string]interface{}{"password": settings.Password}, "shadowsocks")
+		default:
+			p.report.issue("client", c.client.Name, "proxy type ", proxy.Type, " is not supported")
+			continue
+		}
+		for _, tag := range tags {
+			if !excluded[proxy.Id][tag] {
+				c.addInbound(tag)
+				if c.types[tag] == nil {
+					c.types[tag] = map[string]bool{}
+				}
+				c.types[tag][protocol] = true
+			}
+		}
+	}
+	return nil
+}
+
+func marzbanTime(value string) (int64, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	// Stored in UTC by SQLAlchemy, optionally with fractional seconds
+	t, err := time.Parse("2006-01-02 15:04:05", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type PanelImportIssue struct {
+	Kind   string `json:"kind"` // "panel", "inbound" or "client"
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Result of importing the database of another panel
+type PanelImportReport struct {
+	Source   string             `json:"source"`
+	DryRun   bool               `json:"dryRun"`
+	Inbounds []string           `json:"inbounds"`
+	Clients  []string           `json:"clients"`
+	Tls      int                `json:"tls"`
+	Issues   []PanelImportIssue `json:"issues"`
+}
+
+func (r *PanelImportReport) issue(kind string, name string, reason ...interface{}) {
+	r.Issues = append(r.Issues, PanelImportIssue{
+		Kind:   kind,
+		Name:   name,
+		Reason: strings.TrimSpace(fmt.Sprint(reason...)),
+	})
+}
+
+type importedInbound struct {
+	inbound model.Inbound
+	tls     *model.Tls
+}
+
+type importedClient struct {
+	client   model.Client
+	config   map[string]map[string]interface{}
+	source   map[string]bool // protocols whose credentials came from the source panel
+	inbounds []string
+	types    map[string]map[string]bool // inbound types allowed per tag, when the source has no inbounds
+}
+
+// Inbounds and clients read from the source panel, keyed by tag and name
+type panelImport struct {
+	report          *PanelImportReport
+	inbounds        []*importedInbound
+	clients         []*importedClient
+	byName          map[string]*importedClient
+	includeDisabled bool // import disabled x-ui inbounds as enabled instead of skipping them
+}
+
+func newPanelImport(report *PanelImportReport, includeDisabled bool) *panelImport {
+	return &panelImport{
+		report:          report,
+		byName:          map[string]*importedClient{},
+		includeDisabled: includeDisabled,
+	}
+}
+
+// The client of the given name, created on first use
+func (p *panelImport) client(name string) (*importedClient, bool) {
+	if c, ok := p.byName[name]; ok {
+		return c, false
+	}
+	c := &importedClient{
+		client: model.Client{
+			Enable:   true,
+			Name:     name,
+			SubToken: common.NewSubToken(),
+		},
+		config: RandomClientConfig(name),
+		source: map[string]bool{},
+		types:  map[string]map[string]bool{},
+	}
+	p.byName[name] = c
+	p.clients = append(p.clients, c)
+	return c, true
+}
+
+// Set credentials of protocols. Conflicting credentials of a merged client keep the first ones.
+func (p *panelImport) setCredentials(c *importedClient, fields map[string]interface{}, protocols ...string) {
+	for _, protocol := range protocols {
+		if p.hasConflict(c, protocol, fields) {
+			p.report.issue("client", c.client.Name, "conflicting ", protocol, " credentials in several inbounds, the first ones are kept")
+			return
+		}
+	}
+	for _, protocol := range protocols {
+		for key, value := range fields {
+			c.config[protocol][key] = value
+		}
+		c.source[protocol] = true
+	}
+}
+
+func (p *panelImport) hasConflict(c *importedClient, protocol string, fields map[string]interface{}) bool {
+	if !c.source[protocol] {
+		return false
+	}
+	for key, value := range fields {
+		if fmt.Sprint(c.config[protocol][key]) != fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *importedClient) addInbound(tag string) {
+	for _, t := range c.inbounds {
+		if t == tag {
+			return
+		}
+	}
+	c.inbounds = append(c.inbounds, tag)
+}
+
+// Open the database of another panel read-only and detect its kind
+func openPanelDb(path string) (*gorm.DB, string, error) {
+	src, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, "", err
+	}
+	migrator := src.Migrator()
+	switch {
+	case migrator.HasTable("inbounds") && migrator.HasColumn("inbounds", "stream_settings"):
+		if migrator.HasTable("client_traffics") {
+			return src, "3x-ui", nil
+		}
+		return src, "x-ui", nil
+	case migrator.HasTable("users") && migrator.HasTable("proxies"):
+		return src, "marzban", nil
+	}
+	closePanelDb(src)
+	return nil, "", common.NewError("unknown panel database: ", path)
+}
+
+func closePanelDb(src *gorm.DB) {
+	if sqlDb, err := src.DB(); err == nil {
+		sqlDb.Close()
+	}
+}
+
+// Import inbounds, clients and their traffic from an x-ui, 3x-ui or Marzban database.
+// Inbounds and clients which already exist are skipped, so are disabled inbounds unless includeDisabled is set.
+// Nothing is saved on a dry run.
+func (s *ConfigService) ImportPanel(path string, dryRun bool, includeDisabled bool, loginUser string, hostname string) (*PanelImportReport, error) {
+	src, source, err := openPanelDb(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closePanelDb(src)
+
+	report := &PanelImportReport{
+		Source:   source,
+		DryRun:   dryRun,
+		Inbounds: []string{},
+		Clients:  []string{},
+		Issues:   []PanelImportIssue{},
+	}
+	p := newPanelImport(report, includeDisabled)
+	if source == "marzban" {
+		err = p.readMarzban(src)
+	} else {
+		err = p.readXui(src, source == "3x-ui")
+	}
+	if err != nil {
+		return nil, common.NewError("unable to read ", source, " database: ", err)
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	inboundIds, err := p.write(tx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun || (len(report.Inbounds) == 0 && len(report.Clients) == 0) {
+		return report, nil
+	}
+
+	obj, err := json.Marshal(map[string]interface{}{
+		"source":   source,
+		"inbounds": report.Inbounds,
+		"clients":  report.Clients,
+	})
+	if err != nil {
+		return nil, err
+	}
+	change := model.Changes{
+		DateTime: time.Now().Unix(),
+		Actor:    loginUser,
+		Key:      "clients",
+		Action:   "import",
+		Obj:      obj,
+	}
+	err = tx.Create(&change).Error
+	if err != nil {
+		return nil, err
+	}
+	err = enqueueWebhooks(tx, change)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+	LastUpdate = time.Now().Unix()
+
+	// The command line import runs without a core
+	if corePtr != nil && corePtr.IsRunning() {
+		err = s.InboundService.RestartInbounds(db, inboundIds)
+		if err != nil {
+			report.issue("panel", "", "imported inbounds are not running: ", err)
+		}
+		s.applyClientLimits()
+	}
+	return report, nil
+}
+
+// Save the imported inbounds and clients. Returns the inbounds whose users changed.
+func (p *panelImport) write(tx *gorm.DB, hostname string) ([]uint, error) {
+	var existing []*model.Inbound
+	err := tx.Model(model.Inbound{}).Preload("Tls").Find(&existing).Error
+	if err != nil {
+		return nil, err
+	}
+	inbounds := make(map[string]*model.Inbound, len(existing))
+	for _, inbound := range existing {
+		inbounds[inbound.Tag] = inbound
+	}
+	var names []string
+	err = tx.Model(model.Client{}).Pluck("name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+	existingNames := make(map[string]bool, len(names))
+	for _, name := range names {
+		existingNames[name] = true
+	}
+
+	// Clients of skipped inbounds must not join the existing inbound of the same tag
+	skipped := map[string]bool{}
+	var importedIds []uint
+	for _, item := range p.inbounds {
+		inbound := item.inbound
+		if _, ok := inbounds[inbound.Tag]; ok {
+			p.report.issue("inbound", inbound.Tag, "an inbound with this tag already exists")
+			skipped[inbound.Tag] = true
+			continue
+		}
+		if item.tls != nil {
+			err = tx.Create(item.tls).Error
+			if err != nil {
+				return nil, err
+			}
+			inbound.TlsId = item.tls.Id
+			inbound.Tls = item.tls
+			p.report.Tls++
+		}
+		err = util.FillOutJson(&inbound, hostname)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Omit("Tls").Create(&inbound).Error
+		if err != nil {
+			return nil, err
+		}
+		inbounds[inbound.Tag] = &inbound
+		importedIds = append(importedIds, inbound.Id)
+		p.report.Inbounds = append(p.report.Inbounds, inbound.Tag)
+	}
+
+	var inboundIds []uint
+	for _, item := range p.clients {
+		client := item.client
+		if existingNames[client.Name] {
+			p.report.issue("client", client.Name, "a client with this name already exists")
+			continue
+		}
+		client.Config, err = json.MarshalIndent(item.config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		ids := []uint{}
+		links := []map[string]string{}
+		for _, tag := range item.inbounds {
+			inbound, ok := inbounds[tag]
+			if !ok || skipped[tag] {
+				continue
+			}
+			if allowed, ok := item.types[tag]; ok && !allowed[inbound.Type] {
+				continue
+			}
+			ids = append(ids, inbound.Id)
+			for _, uri := range util.LinkGenerator(client.Config, inbound, hostname) {
+				links = append(links, map[string]string{
+					"remark": inbound.Tag,
+					"type":   "local",
+					"uri":    uri,
+				})
+			}
+		}
+		client.Inbounds, err = json.MarshalIndent(ids, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		client.Links, err = json.MarshalIndent(links, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Create(&client).Error
+		if err != nil {
+			return nil, err
+		}
+		existingNames[client.Name] = true
+		inboundIds = common.UnionUintArray(inboundIds, ids)
+		p.report.Clients = append(p.report.Clients, client.Name)
+	}
+	return common.UnionUintArray(inboundIds, importedIds), nil
+}
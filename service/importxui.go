@@ -0,0 +1,463 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/alireza0/s-ui/database/model"
+
+	"gorm.io/gorm"
+)
+
+type xuiInbound struct {
+	Id             uint
+	Up             int64
+	Down           int64
+	Total          int64
+	Remark         string
+	Enable         bool
+	ExpiryTime     int64 // milliseconds
+	Listen         string
+	Port           int
+	Protocol       string
+	Settings       string
+	StreamSettings string
+	Tag            string
+}
+
+type xuiClientTraffic struct {
+	InboundId  uint
+	Enable     bool
+	Email      string
+	Up         int64
+	Down       int64
+	ExpiryTime int64 // milliseconds, negative is the duration after the first use
+	Total      int64
+}
+
+type xuiClient struct {
+	Id         string `json:"id"`
+	AlterId    int    `json:"alterId"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Flow       string `json:"flow"`
+	Email      string `json:"email"`
+	LimitIp    int    `json:"limitIp"`
+	TotalGB    int64  `json:"totalGB"` // bytes despite the name
+	ExpiryTime int64  `json:"expiryTime"`
+	Enable     *bool  `json:"enable"`
+	Comment    string `json:"comment"`
+	Reset      int    `json:"reset"` // days
+}
+
+type xuiSettings struct {
+	Clients   []xuiClient   `json:"clients"`
+	Method    string        `json:"method"`
+	Password  string        `json:"password"`
+	Network   string        `json:"network"`
+	Fallbacks []interface{} `json:"fallbacks"`
+	Accounts  []struct {
+		User string `json:"user"`
+		Pass string `json:"pass"`
+	} `json:"accounts"`
+}
+
+type xuiCertificate struct {
+	CertificateFile string   `json:"certificateFile"`
+	KeyFile         string   `json:"keyFile"`
+	Certificate     []string `json:"certificate"`
+	Key             []string `json:"key"`
+}
+
+type xuiStream struct {
+	Network     string `json:"network"`
+	Security    string `json:"security"`
+	TlsSettings struct {
+		ServerName   string           `json:"serverName"`
+		Alpn         []string         `json:"alpn"`
+		Certificates []xuiCertificate `json:"certificates"`
+		Settings     struct {
+			AllowInsecure bool   `json:"allowInsecure"`
+			Fingerprint   string `json:"fingerprint"`
+		} `json:"settings"`
+	} `json:"tlsSettings"`
+	RealitySettings struct {
+		Dest        string   `json:"dest"`
+		Target      string   `json:"target"`
+		ServerNames []string `json:"serverNames"`
+		PrivateKey  string   `json:"privateKey"`
+		ShortIds    []string `json:"shortIds"`
+		Settings    struct {
+			PublicKey   string `json:"publicKey"`
+			Fingerprint string `json:"fingerprint"`
+		} `json:"settings"`
+	} `json:"realitySettings"`
+	TcpSettings struct {
+		Header struct {
+			Type string `json:"type"`
+		} `json:"header"`
+	} `json:"tcpSettings"`
+	WsSettings struct {
+		Path    string            `json:"path"`
+		Host    string            `json:"host"`
+		Headers map[string]string `json:"headers"`
+	} `json:"wsSettings"`
+	GrpcSettings struct {
+		ServiceName string `json:"serviceName"`
+	} `json:"grpcSettings"`
+	HttpupgradeSettings struct {
+		Path string `json:"path"`
+		Host string `json:"host"`
+	} `json:"httpupgradeSettings"`
+	HttpSettings struct {
+		Path string   `json:"path"`
+		Host []string `json:"host"`
+	} `json:"httpSettings"`
+}
+
+// Inbound protocols of x-ui and their sing-box type
+var xuiProtocols = map[string]string{
+	"vless":       "vless",
+	"vmess":       "vmess",
+	"trojan":      "trojan",
+	"shadowsocks": "shadowsocks",
+	"socks":       "socks",
+	"http":        "http",
+	"mixed":       "mixed",
+}
+
+func (p *panelImport) readXui(src *gorm.DB, clientTraffics bool) error {
+	var rows []xuiInbound
+	err := src.Table("inbounds").Order("id").Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	traffics := map[string]xuiClientTraffic{}
+	if clientTraffics {
+		var trafficRows []xuiClientTraffic
+		err = src.Table("client_traffics").Find(&trafficRows).Error
+		if err != nil {
+			return err
+		}
+		for _, traffic := range trafficRows {
+			traffics[traffic.Email] = traffic
+		}
+	}
+
+	tags := map[string]bool{}
+	for _, row := range rows {
+		tag := xuiTag(&row, tags)
+		inboundType, ok := xuiProtocols[row.Protocol]
+		if !ok {
+			p.report.issue("inbound", tag, "protocol ", row.Protocol, " is not supported")
+			continue
+		}
+		if !row.Enable {
+			if !p.includeDisabled {
+				p.report.issue("inbound", tag, "the inbound is disabled and was skipped")
+				continue
+			}
+			p.report.issue("inbound", tag, "the inbound was disabled and is imported as enabled")
+		}
+		var settings xuiSettings
+		var stream xuiStream
+		err = json.Unmarshal([]byte(row.Settings), &settings)
+		if err != nil {
+			p.report.issue("inbound", tag, "invalid settings: ", err)
+			continue
+		}
+		if row.StreamSettings != "" {
+			err = json.Unmarshal([]byte(row.StreamSettings), &stream)
+			if err != nil {
+				p.report.issue("inbound", tag, "invalid stream settings: ", err)
+				continue
+			}
+		}
+
+		options := map[string]interface{}{
+			"listen":      row.Listen,
+			"listen_port": row.Port,
+		}
+		if row.Listen == "" {
+			options["listen"] = "::"
+		}
+		if inboundType != "socks" && inboundType != "http" && inboundType != "mixed" {
+			transport, reason := xuiTransport(&stream)
+			if reason != "" {
+				p.report.issue("inbound", tag, reason)
+				continue
+			}
+			if transport != nil {
+				options["transport"] = transport
+			}
+		}
+		tls, reason := xuiTls(&stream, tag)
+		if reason != "" {
+			p.report.issue("inbound", tag, reason)
+			continue
+		}
+		ssProtocol := "shadowsocks"
+		if inboundType == "shadowsocks" {
+			method := settings.Method
+			if method == "" && len(settings.Clients) > 0 {
+				method = settings.Clients[0].Method
+			}
+			options["method"] = method
+			if strings.HasPrefix(method, "2022-") {
+				options["password"] = settings.Password
+			}
+			if settings.Network == "tcp" || settings.Network == "udp" {
+				options["network"] = settings.Network
+			}
+			if method == "2022-blake3-aes-128-gcm" {
+				ssProtocol = "shadowsocks16"
+			}
+		}
+		if len(settings.Fallbacks) > 0 {
+			p.report.issue("inbound", tag, "fallbacks are not supported and were dropped")
+		}
+		if clientTraffics && (row.Total > 0 || row.ExpiryTime > 0) {
+			p.report.issue("inbound", tag, "traffic limit and expiry of the inbound are not supported, only those of its clients are kept")
+		}
+
+		optionsJson, _ := json.MarshalIndent(options, "", "  ")
+		p.inbounds = append(p.inbounds, &importedInbound{
+			inbound: model.Inbound{
+				Type:    inboundType,
+				Tag:     tag,
+				Addrs:   json.RawMessage("[]"),
+				OutJson: json.RawMessage("{}"),
+				Options: optionsJson,
+			},
+			tls: tls,
+		})
+
+		switch inboundType {
+		case "socks", "http", "mixed":
+			for _, account := range settings.Accounts {
+				c, _ := p.client(account.User)
+				p.setCredentials(c, map[string]interface{}{"password": account.Pass}, "socks", "http", "mixed")
+				c.addInbound(tag)
+			}
+			continue
+		case "shadowsocks":
+			if len(settings.Clients) == 0 && settings.Password != "" {
+				// Single user inbound of the original x-ui
+				settings.Clients = []xuiClient{{Password: settings.Password}}
+			}
+		}
+
+		for i, sourceClient := range settings.Clients {
+			name := sourceClient.Email
+			if name == "" {
+				name = tag
+				if len(settings.Clients) > 1 {
+					name += "-" + strconv.Itoa(i+1)
+				}
+			}
+			c, created := p.client(name)
+			switch inboundType {
+			case "vless":
+				flow := sourceClient.Flow
+				if flow != "xtls-rprx-vision" {
+					flow = ""
+				}
+				p.setCredentials(c, map[string]interface{}{"uuid": sourceClient.Id, "flow": flow}, "vless")
+			case "vmess":
+				p.setCredentials(c, map[string]interface{}{"uuid": sourceClient.Id, "alterId": sourceClient.AlterId}, "vmess")
+			case "trojan":
+				p.setCredentials(c, map[string]interface{}{"password": sourceClient.Password}, "trojan")
+			case "shadowsocks":
+				if sourceClient.Method != "" && sourceClient.Method != options["method"] {
+					p.report.issue("client", name, "shadowsocks method ", sourceClient.Method, " differs from the inbound, ", options["method"], " is used")
+				}
+				p.setCredentials(c, map[string]interface{}{"password": sourceClient.Password}, ssProtocol)
+			}
+			c.addInbound(tag)
+			if !created {
+				continue
+			}
+
+			client := &c.client
+			if sourceClient.Enable != nil {
+				client.Enable = *sourceClient.Enable
+			}
+			client.Desc = sourceClient.Comment
+			client.IPLimit = sourceClient.LimitIp
+			if sourceClient.Reset > 0 {
+				client.ResetMode = "days"
+				client.ResetDays = sourceClient.Reset
+			}
+			volume, expiry := sourceClient.TotalGB, sourceClient.ExpiryTime
+			if traffic, ok := traffics[sourceClient.Email]; ok && sourceClient.Email != "" {
+				client.Up = traffic.Up
+				client.Down = traffic.Down
+				client.Enable = client.Enable && traffic.Enable
+				volume, expiry = traffic.Total, traffic.ExpiryTime
+			} else if !clientTraffics {
+				// The original x-ui counts and limits traffic per inbound
+				client.Up, client.Down = row.Up, row.Down
+				volume, expiry = row.Total, row.ExpiryTime
+			}
+			client.Volume = volume
+			if expiry > 0 {
+				client.Expiry = expiry / 1000
+			} else if expiry < 0 {
+				client.ExpiryAfterUse = -expiry / 1000
+			}
+		}
+	}
+	return nil
+}
+
+// The remark is shown in links, so it is preferred over the generated tag
+func xuiTag(row *xuiInbound, tags map[string]bool) string {
+	tag := strings.TrimSpace(row.Remark)
+	if tag == "" {
+		tag = row.Tag
+	}
+	if tag == "" || tags[tag] {
+		tag = "inbound-" + strconv.FormatUint(uint64(row.Id), 10)
+	}
+	tags[tag] = true
+	return tag
+}
+
+// The sing-box transport of a stream, or the reason why it is unsupported
+func xuiTransport(stream *xuiStream) (map[string]interface{}, string) {
+	switch stream.Network {
+	case "", "tcp":
+		if headerType := stream.TcpSettings.Header.Type; headerType != "" && headerType != "none" {
+			return nil, "tcp header " + headerType + " is not supported"
+		}
+		return nil, ""
+	case "ws":
+		host := stream.WsSettings.Host
+		if host == "" {
+			host = stream.WsSettings.Headers["Host"]
+		}
+		transport := map[string]interface{}{"type": "ws", "path": stream.WsSettings.Path}
+		if host != "" {
+			transport["headers"] = map[string]interface{}{"Host": host}
+		}
+		return transport, ""
+	case "grpc":
+		return map[string]interface{}{"type": "grpc", "service_name": stream.GrpcSettings.ServiceName}, ""
+	case "httpupgrade":
+		return map[string]interface{}{
+			"type": "httpupgrade",
+			"path": stream.HttpupgradeSettings.Path,
+			"host": stream.HttpupgradeSettings.Host,
+		}, ""
+	case "http", "h2":
+		transport := map[string]interface{}{"type": "http", "path": stream.HttpSettings.Path}
+		if len(stream.HttpSettings.Host) > 0 {
+			transport["host"] = stream.HttpSettings.Host
+		}
+		return transport, ""
+	}
+	return nil, "transport " + stream.Network + " is not supported"
+}
+
+// The TLS of a stream, or the reason why it is unsupported
+func xuiTls(stream *xuiStream, tag string) (*model.Tls, string) {
+	var server, client map[string]interface{}
+	switch stream.Security {
+	case "", "none":
+		return nil, ""
+	case "tls":
+		settings := stream.TlsSettings
+		server = map[string]interface{}{"enabled": true}
+		client = map[string]interface{}{}
+		if settings.ServerName != "" {
+			server["server_name"] = settings.ServerName
+			client["server_name"] = settings.ServerName
+		}
+		if len(settings.Alpn) > 0 {
+			server["alpn"] = settings.Alpn
+		}
+		if len(settings.Certificates) == 0 {
+			return nil, "tls has no certificate"
+		}
+		cert := settings.Certificates[0]
+		if cert.CertificateFile != "" {
+			server["certificate_path"] = cert.CertificateFile
+			server["key_path"] = cert.KeyFile
+		} else {
+			server["certificate"] = cert.Certificate
+			server["key"] = cert.Key
+		}
+		if settings.Settings.AllowInsecure {
+			client["insecure"] = true
+		}
+		if settings.Settings.Fingerprint != "" {
+			client["utls"] = map[string]interface{}{"enabled": true, "fingerprint": settings.Settings.Fingerprint}
+		}
+	case "reality":
+		settings := stream.RealitySettings
+		dest := settings.Target
+		if dest == "" {
+			dest = settings.Dest
+		}
+		host, portStr, err := net.SplitHostPort(dest)
+		if err != nil {
+			host, portStr = dest, "443"
+		}
+		port, _ := strconv.Atoi(portStr)
+		publicKey := settings.Settings.PublicKey
+		if publicKey == "" {
+			publicKey = realityPublicKey(settings.PrivateKey)
+		}
+		if publicKey == "" {
+			return nil, "reality private key is invalid"
+		}
+		serverName := host
+		if len(settings.ServerNames) > 0 {
+			serverName = settings.ServerNames[0]
+		}
+		fingerprint := settings.Settings.Fingerprint
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+		server = map[string]interface{}{
+			"enabled":     true,
+			"server_name": serverName,
+			"reality": map[string]interface{}{
+				"enabled":     true,
+				"handshake":   map[string]interface{}{"server": host, "server_port": port},
+				"private_key": settings.PrivateKey,
+				"short_id":    settings.ShortIds,
+			},
+		}
+		client = map[string]interface{}{
+			"utls":    map[string]interface{}{"enabled": true, "fingerprint": fingerprint},
+			"reality": map[string]interface{}{"enabled": true, "public_key": publicKey, "short_id": ""},
+		}
+	default:
+		return nil, "security " + stream.Security + " is not supported"
+	}
+	serverJson, _ := json.MarshalIndent(server, "", "  ")
+	clientJson, _ := json.MarshalIndent(client, "", "  ")
+	return &model.Tls{
+		Name:   tag,
+		Server: serverJson,
+		Client: clientJson,
+	}, ""
+}
+
+// Public key of a reality private key in the encoding of xray
+func realityPublicKey(privateKey string) string {
+	key, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return ""
+	}
+	private, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(private.PublicKey().Bytes())
+}
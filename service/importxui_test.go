@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestXuiTransport(t *testing.T) {
+	cases := []struct {
+		stream string
+		want   map[string]interface{}
+		reason bool
+	}{
+		{`{}`, nil, false},
+		{`{"network":"tcp","tcpSettings":{"header":{"type":"none"}}}`, nil, false},
+		{`{"network":"tcp","tcpSettings":{"header":{"type":"http"}}}`, nil, true},
+		{`{"network":"ws","wsSettings":{"path":"/ws","headers":{"Host":"a.com"}}}`,
+			map[string]interface{}{"type": "ws", "path": "/ws", "headers": map[string]interface{}{"Host": "a.com"}}, false},
+		{`{"network":"ws","wsSettings":{"path":"/ws","host":"b.com","headers":{"Host":"a.com"}}}`,
+			map[string]interface{}{"type": "ws", "path": "/ws", "headers": map[string]interface{}{"Host": "b.com"}}, false},
+		{`{"network":"grpc","grpcSettings":{"serviceName":"svc"}}`,
+			map[string]interface{}{"type": "grpc", "service_name": "svc"}, false},
+		{`{"network":"httpupgrade","httpupgradeSettings":{"path":"/up","host":"a.com"}}`,
+			map[string]interface{}{"type": "httpupgrade", "path": "/up", "host": "a.com"}, false},
+		{`{"network":"h2","httpSettings":{"path":"/h2","host":["a.com"]}}`,
+			map[string]interface{}{"type": "http", "path": "/h2", "host": []string{"a.com"}}, false},
+		{`{"network":"kcp"}`, nil, true},
+		{`{"network":"xhttp"}`, nil, true},
+	}
+	for _, c := range cases {
+		var stream xuiStream
+		json.Unmarshal([]byte(c.stream), &stream)
+		got, reason := xuiTransport(&stream)
+		if (reason != "") != c.reason {
+			t.Errorf("%s: expected unsupported %v, got %q", c.stream, c.reason, reason)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.stream, c.want, got)
+		}
+	}
+}
+
+func TestXuiTls(t *testing.T) {
+	privateKey := base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	cases := []struct {
+		stream string
+		server string // expected server config, empty for no TLS
+		reason bool
+	}{
+		{`{}`, "", false},
+		{`{"security":"none"}`, "", false},
+		{`{"security":"tls","tlsSettings":{"serverName":"a.com"}}`, "", true},
+		{`{"security":"tls","tlsSettings":{"serverName":"a.com","alpn":["h2"],"certificates":[{"certificateFile":"/c.pem","keyFile":"/k.pem"}]}}`,
+			`{"alpn":["h2"],"certificate_path":"/c.pem","enabled":true,"key_path":"/k.pem","server_name":"a.com"}`, false},
+		{`{"security":"reality","realitySettings":{"dest":"b.com:8443","privateKey":"` + privateKey + `","shortIds":["ab"]}}`,
+			`{"enabled":true,"reality":{"enabled":true,"handshake":{"server":"b.com","server_port":8443},"private_key":"` + privateKey + `","short_id":["ab"]},"server_name":"b.com"}`, false},
+		{`{"security":"reality","realitySettings":{"target":"b.com","serverNames":["c.com"],"privateKey":"` + privateKey + `"}}`,
+			`{"enabled":true,"reality":{"enabled":true,"handshake":{"server":"b.com","server_port":443},"private_key":"` + privateKey + `","short_id":null},"server_name":"c.com"}`, false},
+		{`{"security":"reality","realitySettings":{"dest":"b.com:443","privateKey":"invalid"}}`, "", true},
+		{`{"security":"xtls"}`, "", true},
+	}
+	for _, c := range cases {
+		var stream xuiStream
+		json.Unmarshal([]byte(c.stream), &stream)
+		tls, reason := xuiTls(&stream, "in")
+		if (reason != "") != c.reason {
+			t.Errorf("%s: expected unsupported %v, got %q", c.stream, c.reason, reason)
+		}
+		if c.server == "" {
+			if tls != nil {
+				t.Errorf("%s: expected no TLS, got %s", c.stream, tls.Server)
+			}
+			continue
+		}
+		if tls == nil {
+			t.Errorf("%s: expected TLS", c.stream)
+			continue
+		}
+		var server interface{}
+		json.Unmarshal(tls.Server, &server)
+		got, _ := json.Marshal(server)
+		if string(got) != c.server || tls.Name != "in" {
+			t.Errorf("%s: expected %s, got %s", c.stream, c.server, got)
+		}
+	}
+
+	// The client of a reality inbound gets the public key of its private key
+	var stream xuiStream
+	json.Unmarshal([]byte(`{"security":"reality","realitySettings":{"dest":"b.com:443","privateKey":"`+privateKey+`"}}`), &stream)
+	tls, _ := xuiTls(&stream, "in")
+	var client map[string]map[string]interface{}
+	json.Unmarshal(tls.Client, &client)
+	if client["reality"]["public_key"] != realityPublicKey(privateKey) || client["utls"]["fingerprint"] != "chrome" {
+		t.Errorf("unexpected reality client %s", tls.Client)
+	}
+}
+
+func TestMarzbanTime(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"1700000000", 1700000000, false},
+		{"2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix(), false},
+		{"2024-01-02 03:04:05", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix(), false},
+		{"2024-01-02 03:04:05.123456", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix(), false},
+		{"2024-01-02", 0, true},
+		{"", 0, true},
+	}
+	for _, c := range cases {
+		got, err := marzbanTime(c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%q: expected error %v, got %v", c.value, c.wantErr, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q: expected %d, got %d", c.value, c.want, got)
+		}
+	}
+}
+
+func TestSetCredentials(t *testing.T) {
+	cases := []struct {
+		first    map[string]interface{}
+		second   map[string]interface{}
+		want     string
+		conflict bool
+	}{
+		{map[string]interface{}{"password": "a"}, map[string]interface{}{"password": "a"}, "a", false},
+		{map[string]interface{}{"password": "a"}, map[string]interface{}{"password": "b"}, "a", true},
+		{nil, map[string]interface{}{"password": "b"}, "b", false},
+	}
+	for i, c := range cases {
+		p := newPanelImport(&PanelImportReport{}, false)
+		client, _ := p.client("alice")
+		if c.first != nil {
+			p.setCredentials(client, c.first, "socks", "http", "mixed")
+		}
+		p.setCredentials(client, c.second, "socks", "http", "mixed")
+		for _, protocol := range []string{"socks", "http", "mixed"} {
+			if got := client.config[protocol]["password"]; got != c.want || !client.source[protocol] {
+				t.Errorf("case %d: expected %s password %s from the source, got %v", i, protocol, c.want, got)
+			}
+		}
+		if conflict := len(p.report.Issues) > 0; conflict != c.conflict {
+			t.Errorf("case %d: expected conflict %v, got %v", i, c.conflict, p.report.Issues)
+		}
+	}
+}
+
+func TestReadXui(t *testing.T) {
+	src := newTestDB(t)
+	err := src.Table("inbounds").AutoMigrate(&xuiInbound{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbounds := []xuiInbound{
+		{Id: 1, Remark: "one", Enable: true, Port: 1001, Protocol: "vless",
+			Settings: `{"clients":[{"id":"11111111-1111-1111-1111-111111111111","email":"alice","flow":"xtls-rprx-vision"},{"id":"22222222-2222-2222-2222-222222222222","email":"bob"}]}`},
+		{Id: 2, Remark: "two", Enable: true, Port: 1002, Protocol: "vless",
+			Settings: `{"clients":[{"id":"11111111-1111-1111-1111-111111111111","email":"alice","flow":"xtls-rprx-vision"},{"id":"33333333-3333-3333-3333-333333333333","email":"bob"}]}`},
+		{Id: 3, Remark: "off", Enable: false, Port: 1003, Protocol: "trojan",
+			Settings: `{"clients":[{"password":"secret","email":"carol"}]}`},
+		{Id: 4, Remark: "old", Enable: true, Port: 1004, Protocol: "dokodemo-door", Settings: `{}`},
+	}
+	for _, inbound := range inbounds {
+		src.Table("inbounds").Create(&inbound)
+	}
+
+	cases := []struct {
+		includeDisabled bool
+		inbounds        []string
+		clients         map[string][]string
+	}{
+		{false, []string{"one", "two"}, map[string][]string{"alice": {"one", "two"}, "bob": {"one", "two"}}},
+		{true, []string{"one", "two", "off"}, map[string][]string{"alice": {"one", "two"}, "bob": {"one", "two"}, "carol": {"off"}}},
+	}
+	for i, c := range cases {
+		report := &PanelImportReport{}
+		p := newPanelImport(report, c.includeDisabled)
+		err = p.readXui(src, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tags []string
+		for _, inbound := range p.inbounds {
+			tags = append(tags, inbound.inbound.Tag)
+		}
+		if !reflect.DeepEqual(tags, c.inbounds) {
+			t.Errorf("case %d: expected inbounds %v, got %v", i, c.inbounds, tags)
+		}
+		clients := map[string][]string{}
+		for _, client := range p.clients {
+			clients[client.client.Name] = client.inbounds
+		}
+		if !reflect.DeepEqual(clients, c.clients) {
+			t.Errorf("case %d: expected clients %v, got %v", i, c.clients, clients)
+		}
+
+		// Matching credentials merge, conflicting ones keep the first inbound's
+		if uuid := p.byName["alice"].config["vless"]["uuid"]; uuid != "11111111-1111-1111-1111-111111111111" {
+			t.Errorf("case %d: unexpected uuid of alice %v", i, uuid)
+		}
+		if uuid := p.byName["bob"].config["vless"]["uuid"]; uuid != "22222222-2222-2222-2222-222222222222" {
+			t.Errorf("case %d: unexpected uuid of bob %v", i, uuid)
+		}
+		issues := map[string]bool{}
+		for _, issue := range report.Issues {
+			issues[issue.Kind+" "+issue.Name] = true
+		}
+		want := map[string]bool{"client bob": true, "inbound off": true, "inbound old": true}
+		if !reflect.DeepEqual(issues, want) {
+			t.Errorf("case %d: expected issues of %v, got %v", i, want, report.Issues)
+		}
+	}
+}
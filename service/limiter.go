@@ -0,0 +1,281 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+const (
+	limiterFreeAttempts = 3
+	limiterBaseDelay    = time.Second
+	limiterMaxDelay     = 5 * time.Minute
+	limiterBanAttempts  = 10
+	limiterBanDuration  = 30 * time.Minute
+	limiterWindow       = time.Hour
+)
+
+type limiterEntry struct {
+	failures     int
+	pending      int // attempts which passed Check and are still being verified
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+type loginLimiter struct {
+	sync.Mutex
+	entries map[string]*limiterEntry
+	loaded  bool
+}
+
+var limiter = &loginLimiter{entries: make(map[string]*limiterEntry)}
+
+type LimiterService struct {
+}
+
+func limiterKey(scope string, value string) string {
+	return scope + ":" + value
+}
+
+// Load the persisted bans once, so lockouts survive a restart
+func (l *loginLimiter) load() {
+	if l.loaded {
+		return
+	}
+	l.loaded = true
+	var bans []model.Ban
+	err := database.GetDB().Model(model.Ban{}).Where("until > ?", time.Now().Unix()).Find(&bans).Error
+	if err != nil {
+		logger.Warning("unable to load bans: ", err)
+		return
+	}
+	for _, ban := range bans {
+		l.entries[limiterKey(ban.Scope, ban.Value)] = &limiterEntry{
+			failures:     ban.Failures,
+			lastFailure:  time.Unix(ban.Since, 0),
+			blockedUntil: time.Unix(ban.Until, 0),
+		}
+	}
+}
+
+// Check returns an error while any of the given ip/username is still blocked. Otherwise it
+// reserves the attempt, which must end with Fail, Success or Release. Parallel attempts are
+// limited to the failures still free, so they can not all pass before the first one fails.
+func (s *LimiterService) Check(ip string, username string) error {
+	limiter.Lock()
+	defer limiter.Unlock()
+	limiter.load()
+
+	keys := s.keys(ip, username)
+	err := s.blocked(keys)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		entry, ok := limiter.entries[key]
+		if !ok {
+			continue
+		}
+		allowed := limiterFreeAttempts - entry.failures
+		if allowed < 1 {
+			allowed = 1
+		}
+		if entry.pending >= allowed {
+			return common.NewError("too many attempts in progress, try again later")
+		}
+	}
+	for _, key := range keys {
+		entry, ok := limiter.entries[key]
+		if !ok {
+			entry = &limiterEntry{}
+			limiter.entries[key] = entry
+		}
+		entry.pending++
+	}
+	return nil
+}
+
+// TokenBlocked returns an error while api token failures of the ip are backed off. Tokens are counted
+// apart from logins, so neither a valid token nor a stream of invalid ones touches the login counters.
+func (s *LimiterService) TokenBlocked(ip string) error {
+	limiter.Lock()
+	defer limiter.Unlock()
+	limiter.load()
+	return s.blocked([]string{limiterKey("token", ip)})
+}
+
+func (s *LimiterService) blocked(keys []string) error {
+	now := time.Now()
+	for _, key := range keys {
+		entry, ok := limiter.entries[key]
+		if ok && entry.blockedUntil.After(now) {
+			return common.NewErrorf("too many failed attempts, try again in %v", entry.blockedUntil.Sub(now).Round(time.Second))
+		}
+	}
+	return nil
+}
+
+type limiterBan struct {
+	scope string
+	value string
+	entry limiterEntry
+}
+
+// Fail records a failed attempt and backs off exponentially until the ban threshold is reached
+func (s *LimiterService) Fail(ip string, username string, reason string) {
+	s.fail(map[string]string{"ip": ip, "user": username}, reason)
+}
+
+// TokenFailed records an invalid api token from the ip. The counter is only cleared by the window,
+// a valid token of the same ip does not reset it.
+func (s *LimiterService) TokenFailed(ip string, reason string) {
+	s.fail(map[string]string{"token": ip}, reason)
+}
+
+func (s *LimiterService) fail(values map[string]string, reason string) {
+	var bans []limiterBan
+	limiter.Lock()
+	limiter.load()
+
+	now := time.Now()
+	for key, entry := range limiter.entries {
+		if entry.pending == 0 && now.Sub(entry.lastFailure) > limiterWindow && entry.blockedUntil.Before(now) {
+			delete(limiter.entries, key)
+		}
+	}
+
+	for scope, value := range values {
+		if value == "" {
+			continue
+		}
+		key := limiterKey(scope, value)
+		entry, ok := limiter.entries[key]
+		if !ok {
+			entry = &limiterEntry{}
+			limiter.entries[key] = entry
+		}
+		if entry.pending > 0 {
+			entry.pending--
+		}
+		entry.failures++
+		entry.lastFailure = now
+		switch {
+		case entry.failures >= limiterBanAttempts:
+			entry.blockedUntil = now.Add(limiterBanDuration)
+			bans = append(bans, limiterBan{scope: scope, value: value, entry: *entry})
+		case entry.failures > limiterFreeAttempts:
+			delay := limiterBaseDelay * time.Duration(math.Pow(2, float64(entry.failures-limiterFreeAttempts-1)))
+			if delay > limiterMaxDelay {
+				delay = limiterMaxDelay
+			}
+			entry.blockedUntil = now.Add(delay)
+		}
+	}
+	limiter.Unlock()
+
+	// Saved outside the lock, so other logins do not wait for the database
+	for _, ban := range bans {
+		s.saveBan(ban.scope, ban.value, &ban.entry, reason)
+	}
+}
+
+// Success clears the counters of the given ip/username
+func (s *LimiterService) Success(ip string, username string) {
+	limiter.Lock()
+	defer limiter.Unlock()
+	for _, key := range s.keys(ip, username) {
+		entry, ok := limiter.entries[key]
+		if !ok {
+			continue
+		}
+		if entry.failures < limiterBanAttempts {
+			delete(limiter.entries, key)
+		} else if entry.pending > 0 {
+			entry.pending--
+		}
+	}
+}
+
+// Release ends an attempt reserved by Check which neither failed nor succeeded
+func (s *LimiterService) Release(ip string, username string) {
+	limiter.Lock()
+	defer limiter.Unlock()
+	for _, key := range s.keys(ip, username) {
+		entry, ok := limiter.entries[key]
+		if !ok {
+			continue
+		}
+		if entry.pending > 0 {
+			entry.pending--
+		}
+		if entry.pending == 0 && entry.failures == 0 {
+			delete(limiter.entries, key)
+		}
+	}
+}
+
+func (s *LimiterService) keys(ip string, username string) []string {
+	var keys []string
+	if ip != "" {
+		keys = append(keys, limiterKey("ip", ip))
+	}
+	if username != "" {
+		keys = append(keys, limiterKey("user", username))
+	}
+	return keys
+}
+
+func (s *LimiterService) saveBan(scope string, value string, entry *limiterEntry, reason string) {
+	db := database.GetDB()
+	ban := &model.Ban{}
+	err := db.Model(model.Ban{}).Where("scope = ? and value = ?", scope, value).First(ban).Error
+	if err != nil && !database.IsNotFound(err) {
+		logger.Warning("unable to save ban: ", err)
+		return
+	}
+	ban.Scope = scope
+	ban.Value = value
+	ban.Failures = entry.failures
+	ban.Reason = reason
+	ban.Since = entry.lastFailure.Unix()
+	ban.Until = entry.blockedUntil.Unix()
+	err = db.Save(ban).Error
+	if err != nil {
+		logger.Warning("unable to save ban: ", err)
+		return
+	}
+	logger.Warning("banned ", scope, " ", value, " until ", entry.blockedUntil.Format(time.RFC3339), ": ", reason)
+}
+
+func (s *LimiterService) GetBans() ([]model.Ban, error) {
+	db := database.GetDB()
+	err := db.Where("until < ?", time.Now().Unix()).Delete(model.Ban{}).Error
+	if err != nil {
+		return nil, err
+	}
+	var bans []model.Ban
+	err = db.Model(model.Ban{}).Order("until desc").Find(&bans).Error
+	return bans, err
+}
+
+func (s *LimiterService) Unban(id uint) error {
+	db := database.GetDB()
+	ban := &model.Ban{}
+	err := db.Model(model.Ban{}).Where("id = ?", id).First(ban).Error
+	if err != nil {
+		return err
+	}
+	err = db.Delete(ban).Error
+	if err != nil {
+		return err
+	}
+	limiter.Lock()
+	delete(limiter.entries, limiterKey(ban.Scope, ban.Value))
+	limiter.Unlock()
+	return nil
+}
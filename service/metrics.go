@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+type MetricsService struct{}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Prometheus text exposition format, written by hand to avoid the client library
+type metricsWriter struct {
+	strings.Builder
+}
+
+func (w *metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// One sample of the last family, labels are given as name and value pairs
+func (w *metricsWriter) sample(name string, value interface{}, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 1 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, labels[i], metricsLabelEscaper.Replace(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	fmt.Fprintf(w, " %v\n", value)
+}
+
+// Metrics of the core, its traffic, clients and tested outbounds in the Prometheus text format
+func (s *MetricsService) Metrics() (string, error) {
+	w := &metricsWriter{}
+
+	running := corePtr != nil && corePtr.IsRunning()
+	w.family("sui_core_running", "gauge", "Whether the sing-box core is running.")
+	if running {
+		w.sample("sui_core_running", 1)
+	} else {
+		w.sample("sui_core_running", 0)
+	}
+	w.family("sui_core_uptime_seconds", "gauge", "Seconds since the sing-box core was started.")
+	if running {
+		w.sample("sui_core_uptime_seconds", corePtr.Uptime().Seconds())
+	} else {
+		w.sample("sui_core_uptime_seconds", 0)
+	}
+
+	if running {
+		instance := corePtr.GetInstance()
+		totals := instance.StatsTracker().Totals()
+		families := []struct {
+			resource string
+			label    string
+			help     string
+		}{
+			{"inbound", "tag", "Bytes through an inbound since the panel started."},
+			{"outbound", "tag", "Bytes through an outbound since the panel started."},
+			{"user", "user", "Bytes of a client since the panel started."},
+		}
+		for _, f := range families {
+			name := "sui_" + f.resource + "_bytes_total"
+			w.family(name, "counter", f.help)
+			for _, total := range totals {
+				if total.Resource != f.resource {
+					continue
+				}
+				w.sample(name, total.Up, f.label, total.Tag, "direction", "upload")
+				w.sample(name, total.Down, f.label, total.Tag, "direction", "download")
+			}
+		}
+		w.family("sui_connections", "gauge", "Open connections through the core.")
+		w.sample("sui_connections", instance.ConnTracker().Count())
+	}
+
+	err := s.clientMetrics(w)
+	if err != nil {
+		return "", err
+	}
+	err = s.outboundMetrics(w)
+	if err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+func (s *MetricsService) clientMetrics(w *metricsWriter) error {
+	var counts []struct {
+		Enable         bool
+		DisabledReason string
+		Count          int64
+	}
+	db := database.GetDB()
+	err := db.Model(model.Client{}).Select("enable, disabled_reason, COUNT(*) AS count").
+		Group("enable, disabled_reason").Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	var enabled, disabled int64
+	depleted := map[string]int64{"volume": 0, "expiry": 0}
+	for _, count := range counts {
+		switch {
+		case count.Enable:
+			enabled += count.Count
+		case count.DisabledReason == "volume" || count.DisabledReason == "expiry":
+			depleted[count.DisabledReason] += count.Count
+		default:
+			disabled += count.Count
+		}
+	}
+	w.family("sui_clients", "gauge", "Clients by state, depleted ones were disabled by their volume or expiry.")
+	w.sample("sui_clients", enabled, "state", "enabled")
+	w.sample("sui_clients", disabled, "state", "disabled")
+	for _, reason := range []string{"volume", "expiry"} {
+		w.sample("sui_clients", depleted[reason], "state", "depleted", "reason", reason)
+	}
+	return nil
+}
+
+func (s *MetricsService) outboundMetrics(w *metricsWriter) error {
+	var outbounds []model.Outbound
+	db := database.GetDB()
+	err := db.Model(model.Outbound{}).Select("tag, available, latency, last_test_time").
+		Where("last_test_time > 0").Order("tag").Scan(&outbounds).Error
+	if err != nil {
+		return err
+	}
+	w.family("sui_outbound_up", "gauge", "Whether the last node test of an outbound succeeded.")
+	for _, outbound := range outbounds {
+		up := 0
+		if outbound.Available {
+			up = 1
+		}
+		w.sample("sui_outbound_up", up, "tag", outbound.Tag)
+	}
+	w.family("sui_outbound_latency_milliseconds", "gauge", "Latency of the last successful node test of an outbound.")
+	for _, outbound := range outbounds {
+		if outbound.Available && outbound.Latency >= 0 {
+			w.sample("sui_outbound_latency_milliseconds", outbound.Latency, "tag", outbound.Tag)
+		}
+	}
+	w.family("sui_outbound_last_test_timestamp_seconds", "gauge", "Unix time of the last node test of an outbound.")
+	for _, outbound := range outbounds {
+		w.sample("sui_outbound_last_test_timestamp_seconds", outbound.LastTestTime, "tag", outbound.Tag)
+	}
+	return nil
+}
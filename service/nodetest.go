@@ -913,6 +913,7 @@ func (s *NodeTestService) SaveTestResult(result *NodeTestResult) error {
 	updates := map[string]interface{}{
 		"last_test_time": now,
 		"available":      result.Available,
+		"latency":        result.Latency,
 	}
 
 	// Only update location/IP details if we actually got them
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+const (
+	notifyTimeout = 10 * time.Second
+	smtpTimeout   = 30 * time.Second // whole mail transaction
+)
+
+// Notification of a client approaching its volume or expiry
+type Notification struct {
+	Event     string `json:"event"`
+	Client    string `json:"client"`
+	ClientId  uint   `json:"clientId"`
+	Kind      string `json:"kind"`
+	Threshold int    `json:"threshold"`
+	Used      int64  `json:"used"`
+	Volume    int64  `json:"volume"`
+	Expiry    int64  `json:"expiry"`
+	DateTime  int64  `json:"dateTime"`
+}
+
+func (n *Notification) Text() string {
+	if n.Kind == "volume" {
+		return fmt.Sprintf("Client %s has used %d%% of its volume (%d of %d bytes).", n.Client, n.Threshold, n.Used, n.Volume)
+	}
+	return fmt.Sprintf("Client %s expires in less than %d day(s), at %s.", n.Client, n.Threshold, time.Unix(n.Expiry, 0).Format(time.RFC1123))
+}
+
+type Notifier interface {
+	Name() string
+	Notify(n *Notification) error
+}
+
+// Posts the notification as JSON to a URL
+type HttpNotifier struct {
+	Url string
+}
+
+func (h *HttpNotifier) Name() string {
+	return "http"
+}
+
+func (h *HttpNotifier) Notify(n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", config.GetName()+"/"+config.GetVersion())
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return common.NewError("unexpected status: ", resp.Status)
+	}
+	return nil
+}
+
+// Mails the notification. Port 465 uses implicit TLS, other ports upgrade with STARTTLS when offered.
+type SmtpNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (m *SmtpNotifier) Name() string {
+	return "smtp"
+}
+
+func (m *SmtpNotifier) Notify(n *Notification) error {
+	// Client names come from admins and resellers, the encoding keeps CR/LF out of the headers
+	subject := fmt.Sprintf("[%s] %s warning for %s", config.GetName(), n.Kind, n.Client)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(n.Text() + "\r\n")
+
+	addr := net.JoinHostPort(m.Host, m.Port)
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	tlsConfig := &tls.Config{ServerName: m.Host}
+	var conn net.Conn
+	var err error
+	if m.Port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// A stuck server must not hold up the warn job
+	err = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if m.Port != "465" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if m.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(m.From); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -36,6 +36,7 @@ func (o *OutboundService) GetAll() (*[]map[string]interface{}, error) {
 			"fraudScore":   outbound.FraudScore,
 			"ipType":       outbound.IPType,
 			"available":    outbound.Available,
+			"latency":      outbound.Latency,
 		}
 		if outbound.Options != nil {
 			var restFields map[string]json.RawMessage
@@ -78,6 +79,7 @@ func (o *OutboundService) GetBySubscriptionID(subID string) (*[]map[string]inter
 			"fraudScore":   outbound.FraudScore,
 			"ipType":       outbound.IPType,
 			"available":    outbound.Available,
+			"latency":      outbound.Latency,
 			// Add server/port if available in Options (usually parsed there)
 		}
 
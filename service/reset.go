@@ -0,0 +1,151 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+// First reset time after the last one, at midnight in the panel's time location
+func nextReset(client *model.Client, last time.Time) time.Time {
+	midnight := time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, last.Location())
+	switch client.ResetMode {
+	case "daily":
+		return midnight.AddDate(0, 0, 1)
+	case "weekly":
+		days := (client.ResetDay - int(midnight.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		return midnight.AddDate(0, 0, days)
+	case "monthly":
+		next := monthDay(midnight.Year(), midnight.Month(), client.ResetDay, last.Location())
+		if !next.After(last) {
+			next = monthDay(midnight.Year(), midnight.Month()+1, client.ResetDay, last.Location())
+		}
+		return next
+	case "days":
+		return last.AddDate(0, 0, client.ResetDays)
+	}
+	return time.Time{}
+}
+
+// The given day of a month, clamped to the last day of short months
+func monthDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// Reset the traffic of clients whose schedule is due. Returns the inbounds of re-enabled clients.
+func (s *ClientService) ResetClients(loc *time.Location) ([]uint, error) {
+	var err error
+	var clients []model.Client
+	var inboundIds []uint
+
+	db := database.GetDB()
+	err = db.Model(model.Client{}).Where("reset_mode != ''").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(loc)
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var changes []model.Changes
+	for _, client := range clients {
+		if client.LastReset == 0 {
+			err = tx.Model(model.Client{}).Where("id = ?", client.Id).Update("last_reset", now.Unix()).Error
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		last := time.Unix(client.LastReset, 0).In(loc)
+		if now.Before(nextReset(&client, last)) {
+			continue
+		}
+		logger.Debug("Traffic of client ", client.Name, " is going to be reset")
+
+		err = tx.Create(&model.ClientUsage{
+			ClientId: client.Id,
+			Name:     client.Name,
+			Up:       client.Up,
+			Down:     client.Down,
+			Volume:   client.Volume,
+			From:     client.LastReset,
+			To:       now.Unix(),
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+
+		updates := map[string]interface{}{
+			"up":         0,
+			"down":       0,
+			"last_reset": now.Unix(),
+		}
+		reenable := !client.Enable && client.DisabledReason == "volume" &&
+			(client.Expiry == 0 || client.Expiry > now.Unix())
+		if reenable {
+			updates["enable"] = true
+			updates["disabled_reason"] = ""
+			var userInbounds []uint
+			json.Unmarshal(client.Inbounds, &userInbounds)
+			inboundIds = common.UnionUintArray(inboundIds, userInbounds)
+		}
+		err = tx.Model(model.Client{}).Where("id = ?", client.Id).Updates(updates).Error
+		if err != nil {
+			return nil, err
+		}
+
+		obj, _ := json.Marshal(map[string]interface{}{
+			"name":     client.Name,
+			"up":       client.Up,
+			"down":     client.Down,
+			"reenable": reenable,
+		})
+		changes = append(changes, model.Changes{
+			DateTime: now.Unix(),
+			Actor:    "ResetJob",
+			Key:      "clients",
+			Action:   "reset",
+			Obj:      obj,
+			ObjId:    strconv.FormatUint(uint64(client.Id), 10),
+		})
+	}
+
+	if len(changes) > 0 {
+		err = tx.Model(model.Changes{}).Create(&changes).Error
+		if err != nil {
+			return nil, err
+		}
+		err = enqueueWebhooks(tx, changes...)
+		if err != nil {
+			return nil, err
+		}
+		LastUpdate = now.Unix()
+	}
+	return inboundIds, nil
+}
+
+func (s *ClientService) GetUsageHistory(id string) ([]model.ClientUsage, error) {
+	db := database.GetDB()
+	var history []model.ClientUsage
+	err := db.Model(model.ClientUsage{}).Where("client_id = ?", id).Order("id desc").Find(&history).Error
+	return history, err
+}
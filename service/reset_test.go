@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func TestNextReset(t *testing.T) {
+	loc := time.UTC
+	last := time.Date(2024, 1, 31, 15, 30, 0, 0, loc) // Wednesday
+	cases := []struct {
+		client model.Client
+		want   time.Time
+	}{
+		{model.Client{ResetMode: "daily"}, time.Date(2024, 2, 1, 0, 0, 0, 0, loc)},
+		{model.Client{ResetMode: "weekly", ResetDay: 1}, time.Date(2024, 2, 5, 0, 0, 0, 0, loc)},
+		{model.Client{ResetMode: "weekly", ResetDay: 3}, time.Date(2024, 2, 7, 0, 0, 0, 0, loc)},
+		{model.Client{ResetMode: "monthly", ResetDay: 31}, time.Date(2024, 2, 29, 0, 0, 0, 0, loc)},
+		{model.Client{ResetMode: "monthly", ResetDay: 1}, time.Date(2024, 2, 1, 0, 0, 0, 0, loc)},
+		{model.Client{ResetMode: "days", ResetDays: 10}, time.Date(2024, 2, 10, 15, 30, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		got := nextReset(&c.client, last)
+		if !got.Equal(c.want) {
+			t.Errorf("%s/%d/%d: expected %v, got %v", c.client.ResetMode, c.client.ResetDay, c.client.ResetDays, c.want, got)
+		}
+	}
+
+	// A clamped monthly reset moves back to the chosen day in longer months
+	client := model.Client{ResetMode: "monthly", ResetDay: 31}
+	got := nextReset(&client, time.Date(2024, 2, 29, 0, 0, 1, 0, loc))
+	if want := time.Date(2024, 3, 31, 0, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
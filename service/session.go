@@ -0,0 +1,135 @@
+package service
+
+import (
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+const (
+	// Sessions without a max age end after this long without activity
+	sessionIdleTimeout = 30 * 24 * time.Hour
+	// Last activity is written at most once per interval
+	sessionTouchInterval = 60
+)
+
+type SessionWithUser struct {
+	model.Session
+	Username string `json:"username"`
+	Current  bool   `json:"current" gorm:"-"`
+}
+
+type SessionService struct {
+}
+
+// Create a session and return its token, only the hash of which is stored
+func (s *SessionService) Create(username string, ip string, userAgent string, maxAge int) (string, error) {
+	db := database.GetDB()
+	now := time.Now()
+
+	err := db.Where("(expiry > 0 AND expiry < ?) OR last_active < ?", now.Unix(), now.Add(-sessionIdleTimeout).Unix()).Delete(model.Session{}).Error
+	if err != nil {
+		return "", err
+	}
+
+	var userId uint
+	err = db.Model(model.User{}).Where("username = ?", username).Select("id").Scan(&userId).Error
+	if err != nil {
+		return "", err
+	}
+	if userId == 0 {
+		return "", common.NewError("user not found: ", username)
+	}
+	var expiry int64
+	if maxAge > 0 {
+		expiry = now.Add(time.Duration(maxAge) * time.Minute).Unix()
+	}
+	token := common.Random(32)
+	err = db.Create(&model.Session{
+		Token:      common.HashToken(token),
+		UserId:     userId,
+		IP:         ip,
+		UserAgent:  userAgent,
+		CreatedAt:  now.Unix(),
+		LastActive: now.Unix(),
+		Expiry:     expiry,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Resolve a session token to its username and record the activity. Empty if the session is gone.
+func (s *SessionService) Check(token string, ip string) (uint, string) {
+	if token == "" {
+		return 0, ""
+	}
+	db := database.GetDB()
+	var session SessionWithUser
+	err := db.Model(model.Session{}).
+		Select("sessions.*, users.username").
+		Joins("JOIN users ON users.id = sessions.user_id").
+		Where("sessions.token = ?", common.HashToken(token)).
+		Scan(&session).Error
+	if err != nil || session.Id == 0 {
+		return 0, ""
+	}
+	now := time.Now()
+	if (session.Expiry > 0 && session.Expiry < now.Unix()) || session.LastActive < now.Add(-sessionIdleTimeout).Unix() {
+		db.Delete(model.Session{}, session.Id)
+		return 0, ""
+	}
+	if now.Unix()-session.LastActive > sessionTouchInterval {
+		db.Model(model.Session{}).Where("id = ?", session.Id).Updates(map[string]interface{}{
+			"last_active": now.Unix(),
+			"ip":          ip,
+		})
+	}
+	return session.Id, session.Username
+}
+
+// List the sessions of a user, or of every user when username is empty
+func (s *SessionService) GetSessions(username string) ([]SessionWithUser, error) {
+	db := database.GetDB()
+	var sessions []SessionWithUser
+	query := db.Model(model.Session{}).
+		Select("sessions.*, users.username").
+		Joins("JOIN users ON users.id = sessions.user_id")
+	if username != "" {
+		query = query.Where("users.username = ?", username)
+	}
+	err := query.Order("sessions.last_active desc").Scan(&sessions).Error
+	return sessions, err
+}
+
+// Delete one session. A non-empty username limits it to that user's sessions.
+func (s *SessionService) Revoke(id uint, username string) error {
+	db := database.GetDB()
+	query := db.Where("id = ?", id)
+	if username != "" {
+		query = query.Where("user_id = (select id from users where username = ?)", username)
+	}
+	result := query.Delete(model.Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("session not found")
+	}
+	return nil
+}
+
+// Delete every session of a user except the given one
+func (s *SessionService) RevokeUser(userId uint, exceptId uint) error {
+	db := database.GetDB()
+	return db.Where("user_id = ? AND id != ?", userId, exceptId).Delete(model.Session{}).Error
+}
+
+// Delete the other sessions of the session's user
+func (s *SessionService) RevokeOthers(id uint) error {
+	db := database.GetDB()
+	return db.Where("user_id = (select user_id from sessions where id = ?) AND id != ?", id, id).Delete(model.Session{}).Error
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/core"
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
 	"github.com/alireza0/s-ui/logger"
@@ -52,6 +53,8 @@ var defaultValueMap = map[string]string{
 	"webURI":        "",
 	"sessionMaxAge": "0",
 	"trafficAge":    "30",
+	"hourlyAge":     "180",
+	"dailyAge":      "0",
 	"timeLocation":  "Asia/Tehran",
 	"subListen":     "",
 	"subPort":       "2096",
@@ -62,9 +65,22 @@ var defaultValueMap = map[string]string{
 	"subUpdates":    "12",
 	"subEncode":     "true",
 	"subShowInfo":   "false",
+	"subNameLookup": "false",
 	"subURI":        "",
 	"subJsonExt":    "",
 	"subClashExt":   "",
+	"ipLimitWindow": "300",
+	"metricsEnable": "false",
+	"ipLimitMode":   "reject",
+	"warnVolume":    "80,95",
+	"warnExpiry":    "3,1",
+	"notifyUrl":     "",
+	"smtpHost":      "",
+	"smtpPort":      "587",
+	"smtpUsername":  "",
+	"smtpPassword":  "",
+	"smtpFrom":      "",
+	"smtpTo":        "",
 	"config":        defaultConfig,
 	"version":       config.GetVersion(),
 }
@@ -242,6 +258,16 @@ func (s *SettingService) GetTrafficAge() (int, error) {
 	return s.getInt("trafficAge")
 }
 
+// Days to keep hourly traffic rollups, 0 keeps them forever
+func (s *SettingService) GetHourlyAge() (int, error) {
+	return s.getInt("hourlyAge")
+}
+
+// Days to keep daily traffic rollups, 0 keeps them forever
+func (s *SettingService) GetDailyAge() (int, error) {
+	return s.getInt("dailyAge")
+}
+
 func (s *SettingService) GetTimeLocation() (*time.Location, error) {
 	l, err := s.getString("timeLocation")
 	if err != nil {
@@ -319,6 +345,15 @@ func (s *SettingService) GetSubShowInfo() (bool, error) {
 	return s.getBool("subShowInfo")
 }
 
+// Whether subscriptions may still be fetched by client name besides the token
+func (s *SettingService) GetSubNameLookup() (bool, error) {
+	return s.getBool("subNameLookup")
+}
+
+func (s *SettingService) GetMetricsEnable() (bool, error) {
+	return s.getBool("metricsEnable")
+}
+
 func (s *SettingService) GetSubURI() (string, error) {
 	return s.getString("subURI")
 }
@@ -392,12 +427,24 @@ func (s *SettingService) Save(tx *gorm.DB, data json.RawMessage) error {
 			}
 		}
 
+		if key == "ipLimitMode" && obj != core.IPLimitReject && obj != core.IPLimitDrop {
+			return common.NewError("unknown address limit mode: ", obj)
+		}
+
 		// Delete all stats if it is set to 0
 		if key == "trafficAge" && obj == "0" {
 			err = tx.Where("id > 0").Delete(model.Stats{}).Error
 			if err != nil {
 				return err
 			}
+			err = tx.Where("id > 0").Delete(model.HourlyStats{}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("id > 0").Delete(model.DailyStats{}).Error
+			if err != nil {
+				return err
+			}
 		}
 		err = tx.Model(model.Setting{}).Where("key = ?", key).Update("value", obj).Error
 		if err != nil {
@@ -415,6 +462,54 @@ func (s *SettingService) GetSubClashExt() (string, error) {
 	return s.getString("subClashExt")
 }
 
+func (s *SettingService) GetIPLimitWindow() (int, error) {
+	return s.getInt("ipLimitWindow")
+}
+
+func (s *SettingService) GetIPLimitMode() (string, error) {
+	return s.getString("ipLimitMode")
+}
+
+// Comma separated integers of a setting, invalid entries are skipped
+func (s *SettingService) getIntList(key string) ([]int, error) {
+	str, err := s.getString(key)
+	if err != nil {
+		return nil, err
+	}
+	var list []int
+	for _, item := range strings.Split(str, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(item))
+		if err == nil && value > 0 {
+			list = append(list, value)
+		}
+	}
+	return list, nil
+}
+
+func (s *SettingService) GetWarnVolume() ([]int, error) {
+	return s.getIntList("warnVolume")
+}
+
+func (s *SettingService) GetWarnExpiry() ([]int, error) {
+	return s.getIntList("warnExpiry")
+}
+
+func (s *SettingService) GetNotifyUrl() (string, error) {
+	return s.getString("notifyUrl")
+}
+
+func (s *SettingService) GetSmtp() (map[string]string, error) {
+	smtp := map[string]string{}
+	for _, key := range []string{"smtpHost", "smtpPort", "smtpUsername", "smtpPassword", "smtpFrom", "smtpTo"} {
+		value, err := s.getString(key)
+		if err != nil {
+			return nil, err
+		}
+		smtp[key] = value
+	}
+	return smtp, nil
+}
+
 func (s *SettingService) fileExists(path string) error {
 	_, err := os.Stat(path)
 	return err
@@ -1,8 +1,11 @@
 package service
 
 import (
+	"encoding/json"
+	"strconv"
 	"time"
 
+	"github.com/alireza0/s-ui/core"
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
 
@@ -24,29 +27,86 @@ func (s *StatsService) SaveStats(enableTraffic bool) error {
 	if !corePtr.IsRunning() {
 		return nil
 	}
-	stats := corePtr.GetInstance().StatsTracker().GetStats()
+	return s.saveStats(corePtr.GetInstance(), enableTraffic)
+}
+
+// Save what a stopped box counted since the last StatsJob run on tx. Nothing else saves it
+// when the panel exits or the next box fails to start. A failed flush leaves tx as it was,
+// and the returned function gives the traffic back to the trackers if tx is rolled back later.
+func (s *StatsService) FlushStats(tx *gorm.DB, instance *core.Box) (func(), error) {
+	if instance == nil {
+		return func() {}, nil
+	}
+	return s.flushStats(tx, instance.StatsTracker(), instance.ConnTracker())
+}
+
+func (s *StatsService) flushStats(tx *gorm.DB, tracker *core.StatsTracker, connTracker *core.ConnTracker) (func(), error) {
+	settingService := SettingService{}
+	trafficAge, err := settingService.GetTrafficAge()
+	if err != nil {
+		return func() {}, err
+	}
+	err = tx.SavePoint("flush_stats").Error
+	if err != nil {
+		return func() {}, err
+	}
+	restore, err := s.takeStats(tx, tracker, connTracker, trafficAge > 0)
+	if err != nil {
+		tx.RollbackTo("flush_stats")
+		restore()
+		return func() {}, err
+	}
+	return restore, nil
+}
+
+func (s *StatsService) saveStats(instance *core.Box, enableTraffic bool) error {
+	db := database.GetDB()
+	tx := db.Begin()
+	restore, err := s.takeStats(tx, instance.StatsTracker(), instance.ConnTracker(), enableTraffic)
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	// Traffic and violations which were not saved are taken again by the next run
+	if err != nil {
+		restore()
+	}
+	return err
+}
+
+// Take the counted traffic and violations of the trackers and save them on tx.
+// The returned function gives them back, for when tx is not committed.
+func (s *StatsService) takeStats(tx *gorm.DB, tracker *core.StatsTracker, connTracker *core.ConnTracker, enableTraffic bool) (func(), error) {
+	stats := tracker.GetStats()
+	violations := connTracker.TakeIPViolations()
+	restore := func() {
+		tracker.Restore(stats)
+		connTracker.RestoreIPViolations(violations)
+	}
 
 	// Reset onlines
 	onlineResources.Inbound = nil
 	onlineResources.Outbound = nil
 	onlineResources.User = nil
 
+	err := s.saveIPViolations(tx, violations)
+	if err != nil {
+		return restore, err
+	}
 	if len(*stats) == 0 {
-		return nil
+		return restore, nil
 	}
 
-	var err error
-	db := database.GetDB()
-	tx := db.Begin()
-	defer func() {
-		if err == nil {
-			tx.Commit()
-		} else {
-			tx.Rollback()
-		}
-	}()
-
+	started := map[string]bool{}
 	for _, stat := range *stats {
+		if stat.Resource == "user" && stat.Traffic > 0 && !started[stat.Tag] {
+			started[stat.Tag] = true
+			err = s.startExpiry(tx, stat.Tag)
+			if err != nil {
+				return restore, err
+			}
+		}
 		if stat.Resource == "user" {
 			if stat.Direction {
 				err = tx.Model(model.Client{}).Where("name = ?", stat.Tag).
@@ -56,7 +116,7 @@ func (s *StatsService) SaveStats(enableTraffic bool) error {
 					UpdateColumn("down", gorm.Expr("down + ?", stat.Traffic)).Error
 			}
 			if err != nil {
-				return err
+				return restore, err
 			}
 		}
 		if stat.Direction {
@@ -72,9 +132,45 @@ func (s *StatsService) SaveStats(enableTraffic bool) error {
 	}
 
 	if !enableTraffic {
-		return nil
+		return restore, nil
+	}
+	return restore, tx.Create(stats).Error
+}
+
+// Start the clock of a client which expires relative to its first use
+func (s *StatsService) startExpiry(tx *gorm.DB, name string) error {
+	now := time.Now().Unix()
+	var client model.Client
+	err := tx.Model(model.Client{}).Select("id, expiry_after_use").Where("name = ? AND expiry_after_use > 0", name).Find(&client).Error
+	if err != nil || client.Id == 0 {
+		return err
+	}
+	obj, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	err = tx.Model(model.Client{}).Where("id = ?", client.Id).Updates(map[string]interface{}{
+		"expiry":           now + client.ExpiryAfterUse,
+		"expiry_after_use": 0,
+		"first_use":        now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	change := model.Changes{
+		DateTime: now,
+		Actor:    "StatsJob",
+		Key:      "clients",
+		Action:   "firstUse",
+		Obj:      obj,
+		ObjId:    strconv.FormatUint(uint64(client.Id), 10),
+	}
+	err = tx.Create(&change).Error
+	if err != nil {
+		return err
 	}
-	return tx.Create(&stats).Error
+	LastUpdate = now
+	return enqueueWebhooks(tx, change)
 }
 
 func (s *StatsService) GetStats(resource string, tag string, limit int) ([]model.Stats, error) {
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// Tiers of the traffic history, from the finest to the coarsest
+type statsTier struct {
+	name   string
+	table  string
+	period int64 // seconds summed in one row
+}
+
+var statsTiers = []statsTier{
+	{name: "raw", table: "stats", period: 10},
+	{name: "hourly", table: "hourly_stats", period: 3600},
+	{name: "daily", table: "daily_stats", period: 86400},
+}
+
+const maxTrafficPoints = 5000
+
+type TrafficPoint struct {
+	DateTime int64 `json:"dateTime"`
+	Up       int64 `json:"up"`
+	Down     int64 `json:"down"`
+}
+
+type TrafficHistory struct {
+	Tier   string         `json:"tier"`
+	Bucket int64          `json:"bucket"`
+	Points []TrafficPoint `json:"points"`
+}
+
+// Sum the complete hours of raw stats into hourly stats, and the complete days of those into daily stats.
+// Both are aligned to the local time, zones like +03:30 do not start their hours at a full UTC hour.
+// Every run continues after the last rolled up bucket, so no row is summed twice.
+func (s *StatsService) RollupStats(loc *time.Location) error {
+	now := time.Now().In(loc)
+	_, offset := now.Zone()
+	hour := floorStats(now.Unix(), statsTiers[1].period, int64(offset))
+	year, month, day := now.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, loc).Unix()
+
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	err = rollupStats(tx, statsTiers[0].table, statsTiers[1].table, statsTiers[1].period, int64(offset), hour)
+	if err != nil {
+		return err
+	}
+	err = rollupStats(tx, statsTiers[1].table, statsTiers[2].table, statsTiers[2].period, int64(offset), midnight)
+	return err
+}
+
+// Sum the rows of a finer table into buckets of a coarser one, from the end of its last bucket up to until
+func rollupStats(tx *gorm.DB, from string, to string, period int64, offset int64, until int64) error {
+	var last sql.NullInt64
+	err := tx.Table(to).Select("MAX(date_time)").Row().Scan(&last)
+	if err != nil {
+		return err
+	}
+	start := last.Int64 + period
+	if !last.Valid {
+		var first sql.NullInt64
+		err = tx.Table(from).Select("MIN(date_time)").Row().Scan(&first)
+		if err != nil || !first.Valid {
+			return err
+		}
+		start = floorStats(first.Int64, period, offset)
+	}
+	if start >= until {
+		return nil
+	}
+	bucket := fmt.Sprintf("(date_time + %d) / %d * %d - %d", offset, period, period, offset)
+	return tx.Exec("INSERT INTO "+to+" (date_time, resource, tag, direction, traffic, inbound, outbound) "+
+		"SELECT "+bucket+" AS bucket, resource, tag, direction, SUM(traffic), COALESCE(inbound, ''), COALESCE(outbound, '') "+
+		"FROM "+from+" WHERE date_time >= ? AND date_time < ? "+
+		"GROUP BY bucket, resource, tag, direction, COALESCE(inbound, ''), COALESCE(outbound, '')", start, until).Error
+}
+
+// Start of the bucket of the given period which contains t, in a zone with the given offset
+func floorStats(t int64, period int64, offset int64) int64 {
+	return (t+offset)/period*period - offset
+}
+
+// The coarsest tier whose rows fit in the bucket, or a coarser one if its retention in days no longer keeps from
+func trafficTier(ages []int, from int64, now int64, bucket int64) int {
+	tier := 0
+	for i, t := range statsTiers {
+		if t.period <= bucket {
+			tier = i
+		}
+	}
+	for tier < len(statsTiers)-1 && ages[tier] > 0 && from < now-int64(ages[tier])*86400 {
+		tier++
+	}
+	return tier
+}
+
+// Delete hourly and daily stats older than their retention in days, 0 keeps a tier forever
+func (s *StatsService) DelOldRollups(hourlyAge int, dailyAge int) error {
+	db := database.GetDB()
+	if hourlyAge > 0 {
+		oldTime := time.Now().AddDate(0, 0, -hourlyAge).Unix()
+		err := db.Where("date_time < ?", oldTime).Delete(model.HourlyStats{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	if dailyAge > 0 {
+		oldTime := time.Now().AddDate(0, 0, -dailyAge).Unix()
+		return db.Where("date_time < ?", oldTime).Delete(model.DailyStats{}).Error
+	}
+	return nil
+}
+
+// Traffic of a resource in buckets of the given seconds, starting at the row of the chosen tier which holds from.
+// It reads the coarsest tier which fits the bucket and still keeps the start of the range,
+// and completes the buckets which were not rolled up yet from the finer tiers.
+func (s *StatsService) GetTraffic(resource string, tag string, from int64, to int64, bucket int64) (*TrafficHistory, error) {
+	now := time.Now().Unix()
+	if to <= 0 || to > now {
+		to = now
+	}
+	if from >= to {
+		return nil, common.NewError("invalid time range")
+	}
+
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	ages := make([]int, len(statsTiers))
+	ages[0], err = settingService.GetTrafficAge()
+	if err != nil {
+		return nil, err
+	}
+	ages[1], err = settingService.GetHourlyAge()
+	if err != nil {
+		return nil, err
+	}
+	ages[2], err = settingService.GetDailyAge()
+	if err != nil {
+		return nil, err
+	}
+
+	tier := trafficTier(ages, from, now, bucket)
+	// Buckets hold whole rows of the tier, so they start at a row and span a multiple of its period
+	period := statsTiers[tier].period
+	if bucket < period {
+		bucket = period
+	}
+	bucket = (bucket + period - 1) / period * period
+	_, offset := time.Unix(from, 0).In(loc).Zone()
+	from = floorStats(from, period, int64(offset))
+	if (to-from)/bucket > maxTrafficPoints {
+		return nil, common.NewErrorf("more than %d buckets, use a larger bucket size", maxTrafficPoints)
+	}
+
+	resources := []string{resource}
+	if resource == "endpoint" {
+		resources = []string{"inbound", "outbound"}
+	}
+
+	db := database.GetDB()
+	sums := map[int64]*TrafficPoint{}
+	start := from
+	for i := tier; i >= 0 && start < to; i-- {
+		end := to
+		if i > 0 {
+			// Rows after the last bucket of a rollup are still in the finer tier
+			var last sql.NullInt64
+			err = db.Table(statsTiers[i].table).Select("MAX(date_time)").Row().Scan(&last)
+			if err != nil {
+				return nil, err
+			}
+			if !last.Valid {
+				continue
+			}
+			if last.Int64+statsTiers[i].period < end {
+				end = last.Int64 + statsTiers[i].period
+			}
+		}
+		if end <= start {
+			continue
+		}
+		var rows []struct {
+			Bucket    int64
+			Direction bool
+			Traffic   int64
+		}
+		err = db.Table(statsTiers[i].table).
+			Select(fmt.Sprintf("%d + (date_time - %d) / %d * %d AS bucket, direction, SUM(traffic) AS traffic", from, from, bucket, bucket)).
+			Where("resource in ? AND tag = ? AND date_time >= ? AND date_time < ?", resources, tag, start, end).
+			Group("bucket, direction").Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			point, ok := sums[row.Bucket]
+			if !ok {
+				point = &TrafficPoint{DateTime: row.Bucket}
+				sums[row.Bucket] = point
+			}
+			if row.Direction {
+				point.Up += row.Traffic
+			} else {
+				point.Down += row.Traffic
+			}
+		}
+		start = end
+	}
+
+	result := &TrafficHistory{
+		Tier:   statsTiers[tier].name,
+		Bucket: bucket,
+		Points: []TrafficPoint{},
+	}
+	for t := from; t < to; t += bucket {
+		if point, ok := sums[t]; ok {
+			result.Points = append(result.Points, *point)
+		} else {
+			result.Points = append(result.Points, TrafficPoint{DateTime: t})
+		}
+	}
+	return result, nil
+}
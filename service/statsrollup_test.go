@@ -0,0 +1,135 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func TestTrafficTier(t *testing.T) {
+	now := int64(1700000000)
+	day := int64(86400)
+	cases := []struct {
+		ages   []int
+		from   int64
+		bucket int64
+		want   int
+	}{
+		{[]int{30, 180, 0}, now - 3600, 60, 0},
+		{[]int{30, 180, 0}, now - 3600, 5, 0},
+		{[]int{30, 180, 0}, now - day, 3600, 1},
+		{[]int{30, 180, 0}, now - day, 7200, 1},
+		{[]int{30, 180, 0}, now - day, day, 2},
+		{[]int{30, 180, 0}, now - 40*day, 60, 1},
+		{[]int{30, 180, 0}, now - 200*day, 60, 2},
+		{[]int{0, 180, 0}, now - 400*day, 60, 0},
+		{[]int{30, 0, 0}, now - 400*day, 10, 1},
+	}
+	for i, c := range cases {
+		if got := trafficTier(c.ages, c.from, now, c.bucket); got != c.want {
+			t.Errorf("case %d: expected tier %s, got %s", i, statsTiers[c.want].name, statsTiers[got].name)
+		}
+	}
+}
+
+func TestFloorStats(t *testing.T) {
+	cases := []struct {
+		t      int64
+		period int64
+		offset int64
+		want   int64
+	}{
+		{1700000000, 3600, 0, 1699999200},
+		{1700000000, 3600, 12600, 1699997400}, // +03:30 hours start at half past in UTC
+		{1700000000, 86400, 0, 1699920000},
+		{1700000000, 86400, 12600, 1699993800},
+		{1700000000, 86400, -18000, 1699938000},
+		{1700000005, 10, 12600, 1700000000},
+	}
+	for _, c := range cases {
+		if got := floorStats(c.t, c.period, c.offset); got != c.want {
+			t.Errorf("%d/%d/%d: expected %d, got %d", c.t, c.period, c.offset, c.want, got)
+		}
+	}
+}
+
+func TestRollupStats(t *testing.T) {
+	db := newTestDB(t, &model.Stats{}, &model.HourlyStats{}, &model.DailyStats{})
+	offset := int64(12600)
+	midnight := floorStats(1700000000, 86400, offset)
+	hour := midnight + 5*3600
+	db.Create(&[]model.Stats{
+		{DateTime: hour + 10, Resource: "inbound", Tag: "in", Direction: true, Traffic: 100},
+		{DateTime: hour + 20, Resource: "inbound", Tag: "in", Direction: true, Traffic: 50},
+		{DateTime: hour + 20, Resource: "inbound", Tag: "in", Direction: false, Traffic: 20},
+		{DateTime: hour + 30, Resource: "userRoute", Tag: "user", Inbound: "in", Outbound: "direct", Traffic: 5},
+		{DateTime: hour + 40, Resource: "userRoute", Tag: "user", Inbound: "in", Outbound: "proxy", Traffic: 6},
+		{DateTime: hour + 3605, Resource: "inbound", Tag: "in", Direction: true, Traffic: 7},
+		{DateTime: hour + 7201, Resource: "inbound", Tag: "in", Direction: true, Traffic: 1000},
+	})
+
+	type row struct {
+		DateTime  int64
+		Resource  string
+		Direction bool
+		Outbound  string
+		Traffic   int64
+	}
+	check := func(table string, want []row) {
+		t.Helper()
+		var got []row
+		err := db.Table(table).Select("date_time, resource, direction, outbound, traffic").
+			Order("date_time, resource, direction, outbound").Scan(&got).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %v, got %v", table, want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s row %d: expected %v, got %v", table, i, want[i], got[i])
+			}
+		}
+	}
+
+	// Only complete hours are summed, each into the local hour it started in
+	err := rollupStats(db, "stats", "hourly_stats", 3600, offset, hour+7200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hourly := []row{
+		{hour, "inbound", false, "", 20},
+		{hour, "inbound", true, "", 150},
+		{hour, "userRoute", false, "direct", 5},
+		{hour, "userRoute", false, "proxy", 6},
+		{hour + 3600, "inbound", true, "", 7},
+	}
+	check("hourly_stats", hourly)
+
+	// A later run continues after the last bucket and sums nothing twice
+	for i := 0; i < 2; i++ {
+		err = rollupStats(db, "stats", "hourly_stats", 3600, offset, hour+3*3600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hourly = append(hourly, row{hour + 7200, "inbound", true, "", 1000})
+	check("hourly_stats", hourly)
+
+	err = rollupStats(db, "hourly_stats", "daily_stats", 86400, offset, midnight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("daily_stats", nil)
+	err = rollupStats(db, "hourly_stats", "daily_stats", 86400, offset, midnight+86400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("daily_stats", []row{
+		{midnight, "inbound", false, "", 20},
+		{midnight, "inbound", true, "", 1157},
+		{midnight, "userRoute", false, "direct", 5},
+		{midnight, "userRoute", false, "proxy", 6},
+	})
+}
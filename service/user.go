@@ -1,16 +1,44 @@
 package service
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 
+	"github.com/alireza0/s-ui/config"
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
 	"github.com/alireza0/s-ui/logger"
 	"github.com/alireza0/s-ui/util/common"
 )
 
+// bcrypt hash of a random string, compared against when the username does not exist
+const dummyPasswordHash = "$2a$10$3AwMNnkfxbM8lrUg8QNWEOWhcS3LLAzveYHpzxFP3td8XZ43y8O9S"
+
+var ErrTotpRequired = errors.New("two-factor code required")
+
+// Admin roles
+const (
+	RoleOwner    = "owner"    // full access
+	RoleOperator = "operator" // manage clients only
+	RoleReadOnly = "readonly" // dashboards and stats
+	RoleReseller = "reseller" // manage own clients only
+)
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleOwner, RoleOperator, RoleReadOnly, RoleReseller:
+		return true
+	}
+	return false
+}
+
 type UserService struct {
+	LimiterService
 }
 
 func (s *UserService) GetFirstUser() (*model.User, error) {
@@ -32,26 +60,47 @@ func (s *UserService) UpdateFirstUser(username string, password string) error {
 	} else if password == "" {
 		return common.NewError("password can not be empty")
 	}
+	hashedPassword, err := common.HashPassword(password)
+	if err != nil {
+		return err
+	}
 	db := database.GetDB()
 	user := &model.User{}
-	err := db.Model(model.User{}).First(user).Error
+	err = db.Model(model.User{}).First(user).Error
 	if database.IsNotFound(err) {
 		user.Username = username
-		user.Password = password
+		user.Password = hashedPassword
 		return db.Model(model.User{}).Create(user).Error
 	} else if err != nil {
 		return err
 	}
 	user.Username = username
-	user.Password = password
+	user.Password = hashedPassword
 	return db.Save(user).Error
 }
 
-func (s *UserService) Login(username string, password string, remoteIP string) (string, error) {
+func (s *UserService) Login(username string, password string, code string, remoteIP string) (string, error) {
+	err := s.LimiterService.Check(remoteIP, username)
+	if err != nil {
+		return "", err
+	}
 	user := s.CheckUser(username, password, remoteIP)
 	if user == nil {
+		s.LimiterService.Fail(remoteIP, username, "wrong password")
 		return "", common.NewError("wrong user or password! IP: ", remoteIP)
 	}
+	if user.TotpEnabled {
+		if code == "" {
+			s.LimiterService.Release(remoteIP, username)
+			return "", ErrTotpRequired
+		}
+		if !s.checkSecondFactor(user, code) {
+			s.LimiterService.Fail(remoteIP, username, "wrong two-factor code")
+			return "", common.NewError("wrong two-factor code! IP: ", remoteIP)
+		}
+	}
+	s.LimiterService.Success(remoteIP, username)
+	s.updateLastLogin(user, remoteIP)
 	return user.Username, nil
 }
 
@@ -60,30 +109,49 @@ func (s *UserService) CheckUser(username string, password string, remoteIP strin
 
 	user := &model.User{}
 	err := db.Model(model.User{}).
-		Where("username = ? and password = ?", username, password).
+		Where("username = ?", username).
 		First(user).
 		Error
 	if database.IsNotFound(err) {
+		// Spend the same time as a real check to avoid leaking valid usernames
+		common.CheckPassword(dummyPasswordHash, password)
 		return nil
 	} else if err != nil {
 		logger.Warning("check user err:", err, " IP: ", remoteIP)
 		return nil
 	}
+	if !common.CheckPassword(user.Password, password) {
+		return nil
+	}
+
+	// Upgrade legacy plaintext or outdated hashes on first successful login
+	if common.NeedsRehash(user.Password) {
+		hashedPassword, err := common.HashPassword(password)
+		if err == nil {
+			err = db.Model(model.User{}).Where("id = ?", user.Id).Update("password", hashedPassword).Error
+		}
+		if err != nil {
+			logger.Warning("unable to rehash password of ", username, ": ", err)
+		}
+	}
+	return user
+}
 
+func (s *UserService) updateLastLogin(user *model.User, remoteIP string) {
+	db := database.GetDB()
 	lastLoginTxt := time.Now().Format("2006-01-02 15:04:05") + " " + remoteIP
-	err = db.Model(model.User{}).
-		Where("username = ?", username).
+	err := db.Model(model.User{}).
+		Where("id = ?", user.Id).
 		Update("last_logins", &lastLoginTxt).Error
 	if err != nil {
 		logger.Warning("unable to log login data", err)
 	}
-	return user
 }
 
 func (s *UserService) GetUsers() (*[]model.User, error) {
 	var users []model.User
 	db := database.GetDB()
-	err := db.Model(model.User{}).Select("id,username,last_logins").Scan(&users).Error
+	err := db.Model(model.User{}).Select("id,username,last_logins,role,max_clients,max_volume,max_expiry_days,totp_enabled").Scan(&users).Error
 	if err != nil {
 		return nil, err
 	}
@@ -93,15 +161,165 @@ func (s *UserService) GetUsers() (*[]model.User, error) {
 func (s *UserService) ChangePass(id string, oldPass string, newUser string, newPass string) error {
 	db := database.GetDB()
 	user := &model.User{}
-	err := db.Model(model.User{}).Where("id = ? AND password = ?", id, oldPass).First(user).Error
-	if err != nil || database.IsNotFound(err) {
+	err := db.Model(model.User{}).Where("id = ?", id).First(user).Error
+	if err != nil {
 		return err
 	}
+	if !common.CheckPassword(user.Password, oldPass) {
+		return common.NewError("wrong old password")
+	}
+	if newUser == "" {
+		return common.NewError("username can not be empty")
+	} else if newPass == "" {
+		return common.NewError("password can not be empty")
+	}
 	user.Username = newUser
-	user.Password = newPass
+	user.Password, err = common.HashPassword(newPass)
+	if err != nil {
+		return err
+	}
 	return db.Save(user).Error
 }
 
+func (s *UserService) GetRole(username string) string {
+	db := database.GetDB()
+	var role string
+	err := db.Model(model.User{}).Select("role").Where("username = ?", username).Scan(&role).Error
+	if err != nil {
+		logger.Warning("unable to get role of ", username, ": ", err)
+		return ""
+	}
+	// Users created before roles existed are owners
+	if role == "" {
+		var count int64
+		db.Model(model.User{}).Where("username = ?", username).Count(&count)
+		if count > 0 {
+			return RoleOwner
+		}
+	}
+	return role
+}
+
+func (s *UserService) AddUser(username string, password string, role string) error {
+	if username == "" {
+		return common.NewError("username can not be empty")
+	} else if password == "" {
+		return common.NewError("password can not be empty")
+	} else if !IsValidRole(role) {
+		return common.NewError("invalid role: ", role)
+	}
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.User{}).Where("username = ?", username).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("username already exists")
+	}
+	hashedPassword, err := common.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return db.Create(&model.User{
+		Username: username,
+		Password: hashedPassword,
+		Role:     role,
+	}).Error
+}
+
+// Change role and optionally reset password of another admin
+func (s *UserService) UpdateUser(id string, role string, password string) error {
+	if !IsValidRole(role) {
+		return common.NewError("invalid role: ", role)
+	}
+	db := database.GetDB()
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("id = ?", id).First(user).Error
+	if err != nil {
+		return err
+	}
+	if role != RoleOwner {
+		err = s.checkOtherOwner(user.Id)
+		if err != nil {
+			return err
+		}
+	}
+	user.Role = role
+	if password != "" {
+		user.Password, err = common.HashPassword(password)
+		if err != nil {
+			return err
+		}
+	}
+	return db.Save(user).Error
+}
+
+func (s *UserService) SetResellerQuota(id string, maxClients int, maxVolume int64, maxExpiryDays int) error {
+	if maxClients < 0 || maxVolume < 0 || maxExpiryDays < 0 {
+		return common.NewError("quota can not be negative")
+	}
+	db := database.GetDB()
+	return db.Model(model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"max_clients":     maxClients,
+		"max_volume":      maxVolume,
+		"max_expiry_days": maxExpiryDays,
+	}).Error
+}
+
+// Return the user if it is a reseller, otherwise nil
+func (s *UserService) GetReseller(username string) (*model.User, error) {
+	if username == "" {
+		return nil, nil
+	}
+	user, err := s.getByUsername(username)
+	if database.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if user.Role != RoleReseller {
+		return nil, nil
+	}
+	return user, nil
+}
+
+func (s *UserService) DeleteUser(id string) error {
+	db := database.GetDB()
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("id = ?", id).First(user).Error
+	if err != nil {
+		return err
+	}
+	err = s.checkOtherOwner(user.Id)
+	if err != nil {
+		return err
+	}
+	err = db.Where("user_id = ?", user.Id).Delete(model.Tokens{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("user_id = ?", user.Id).Delete(model.Session{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(user).Error
+}
+
+// Prevent removing the last owner, which would lock everyone out of administration
+func (s *UserService) checkOtherOwner(id uint) error {
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.User{}).Where("id != ? AND (role = ? OR role = '' OR role IS NULL)", id, RoleOwner).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.NewError("at least one owner is required")
+	}
+	return nil
+}
+
 func (s *UserService) LoadTokens() ([]byte, error) {
 	db := database.GetDB()
 	var tokens []model.Tokens
@@ -112,9 +330,13 @@ func (s *UserService) LoadTokens() ([]byte, error) {
 	var result []map[string]interface{}
 	for _, t := range tokens {
 		result = append(result, map[string]interface{}{
-			"token":    t.Token,
-			"expiry":   t.Expiry,
-			"username": t.User.Username,
+			"id":         t.Id,
+			"token":      t.Token,
+			"expiry":     t.Expiry,
+			"username":   t.User.Username,
+			"scopes":     t.Scopes,
+			"allowedIps": t.AllowedIPs,
+			"lastUsed":   t.LastUsed,
 		})
 	}
 	jsonResult, _ := json.MarshalIndent(result, "", "  ")
@@ -124,7 +346,7 @@ func (s *UserService) LoadTokens() ([]byte, error) {
 func (s *UserService) GetUserTokens(username string) (*[]model.Tokens, error) {
 	db := database.GetDB()
 	var token []model.Tokens
-	err := db.Model(model.Tokens{}).Select("id,desc,'****' as token,expiry,user_id").Where("user_id = (select id from users where username = ?)", username).Find(&token).Error
+	err := db.Model(model.Tokens{}).Select("id,desc,'****' as token,expiry,scopes,allowed_ips,last_used,last_ip,user_id").Where("user_id = (select id from users where username = ?)", username).Find(&token).Error
 	if err != nil && !database.IsNotFound(err) {
 		println(err.Error())
 		return nil, err
@@ -132,30 +354,231 @@ func (s *UserService) GetUserTokens(username string) (*[]model.Tokens, error) {
 	return &token, nil
 }
 
-func (s *UserService) AddToken(username string, expiry int64, desc string) (string, error) {
+// Only the hash is stored, the plain token is returned once to the caller.
+// Empty scopes grant every action of the owner's role, empty allowedIPs accept every source.
+func (s *UserService) AddToken(username string, expiry int64, desc string, scopes []string, allowedIPs []string) (string, error) {
+	for _, allowedIP := range allowedIPs {
+		if !common.IsValidIPOrCIDR(allowedIP) {
+			return "", common.NewError("invalid ip or cidr: ", allowedIP)
+		}
+	}
+	scopesJson, err := json.Marshal(scopes)
+	if err != nil {
+		return "", err
+	}
+	allowedIPsJson, err := json.Marshal(allowedIPs)
+	if err != nil {
+		return "", err
+	}
 	db := database.GetDB()
 	var userId uint
-	err := db.Model(model.User{}).Where("username = ?", username).Select("id").Scan(&userId).Error
+	err = db.Model(model.User{}).Where("username = ?", username).Select("id").Scan(&userId).Error
 	if err != nil {
 		return "", err
 	}
 	if expiry > 0 {
 		expiry = expiry*86400 + time.Now().Unix()
 	}
+	plainToken := common.Random(32)
 	token := &model.Tokens{
-		Token:  common.Random(32),
-		Desc:   desc,
-		Expiry: expiry,
-		UserId: userId,
+		Token:      common.HashToken(plainToken),
+		Desc:       desc,
+		Expiry:     expiry,
+		Scopes:     scopesJson,
+		AllowedIPs: allowedIPsJson,
+		UserId:     userId,
 	}
 	err = db.Create(token).Error
 	if err != nil {
 		return "", err
 	}
-	return token.Token, nil
+	return plainToken, nil
+}
+
+func (s *UserService) TouchToken(id uint, remoteIP string) error {
+	db := database.GetDB()
+	return db.Model(model.Tokens{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_used": time.Now().Unix(),
+		"last_ip":   remoteIP,
+	}).Error
+}
+
+func (s *UserService) DeleteToken(id string, username string) error {
+	db := database.GetDB()
+	return db.Model(model.Tokens{}).Where("id = ? AND user_id = (select id from users where username = ?)", id, username).Delete(&model.Tokens{}).Error
 }
 
-func (s *UserService) DeleteToken(id string) error {
+func (s *UserService) getByUsername(username string) (*model.User, error) {
 	db := database.GetDB()
-	return db.Model(model.Tokens{}).Where("id = ?", id).Delete(&model.Tokens{}).Error
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("username = ?", username).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Start TOTP enrollment. The secret is stored but not enforced until it is confirmed by TotpEnable
+func (s *UserService) TotpSetup(username string) (map[string]string, error) {
+	user, err := s.getByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabled {
+		return nil, common.NewError("two-factor authentication is already enabled")
+	}
+	secret, err := common.GenerateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	err = db.Model(model.User{}).Where("id = ?", user.Id).Update("totp_secret", secret).Error
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"secret": secret,
+		"uri":    common.TotpURI(config.GetName(), user.Username, secret),
+	}, nil
+}
+
+func (s *UserService) TotpEnable(username string, code string) ([]string, error) {
+	user, err := s.getByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabled {
+		return nil, common.NewError("two-factor authentication is already enabled")
+	}
+	if user.TotpSecret == "" {
+		return nil, common.NewError("two-factor setup is not started")
+	}
+	step, ok := common.ValidateTotp(user.TotpSecret, code, 0, time.Now())
+	if !ok {
+		return nil, common.NewError("wrong two-factor code")
+	}
+	codes, hashedCodes, err := s.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	err = db.Model(model.User{}).Where("id = ?", user.Id).Updates(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+		"recovery_codes": hashedCodes,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *UserService) TotpDisable(username string, password string) error {
+	user, err := s.getByUsername(username)
+	if err != nil {
+		return err
+	}
+	if !common.CheckPassword(user.Password, password) {
+		return common.NewError("wrong password")
+	}
+	return s.ResetTotp(username)
+}
+
+// Generate a new set of recovery codes, invalidating the old ones
+func (s *UserService) TotpRecoveryCodes(username string, code string) ([]string, error) {
+	user, err := s.getByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TotpEnabled {
+		return nil, common.NewError("two-factor authentication is not enabled")
+	}
+	step, ok := common.ValidateTotp(user.TotpSecret, code, user.TotpLastStep, time.Now())
+	if !ok {
+		return nil, common.NewError("wrong two-factor code")
+	}
+	codes, hashedCodes, err := s.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	err = db.Model(model.User{}).Where("id = ?", user.Id).Updates(map[string]interface{}{
+		"totp_last_step": step,
+		"recovery_codes": hashedCodes,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Remove two-factor authentication without any check, used by CLI for locked-out users
+func (s *UserService) ResetTotp(username string) error {
+	db := database.GetDB()
+	result := db.Model(model.User{}).Where("username = ?", username).Updates(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+		"recovery_codes": "",
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("user not found: ", username)
+	}
+	return nil
+}
+
+func (s *UserService) checkSecondFactor(user *model.User, code string) bool {
+	db := database.GetDB()
+	step, ok := common.ValidateTotp(user.TotpSecret, code, user.TotpLastStep, time.Now())
+	if ok {
+		err := db.Model(model.User{}).Where("id = ?", user.Id).Update("totp_last_step", step).Error
+		if err != nil {
+			logger.Warning("unable to save totp step: ", err)
+			return false
+		}
+		return true
+	}
+
+	// Fall back to one-time recovery codes
+	var hashedCodes []string
+	json.Unmarshal([]byte(user.RecoveryCodes), &hashedCodes)
+	hashedCode := s.hashRecoveryCode(code)
+	for index, stored := range hashedCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hashedCode)) == 1 {
+			hashedCodes = append(hashedCodes[:index], hashedCodes[index+1:]...)
+			remained, _ := json.Marshal(hashedCodes)
+			err := db.Model(model.User{}).Where("id = ?", user.Id).Update("recovery_codes", string(remained)).Error
+			if err != nil {
+				logger.Warning("unable to consume recovery code: ", err)
+				return false
+			}
+			logger.Info("user ", user.Username, " used a recovery code, ", len(hashedCodes), " codes left")
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UserService) newRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, 10)
+	hashedCodes := make([]string, 10)
+	for i := range codes {
+		code := common.Random(10)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashedCodes[i] = s.hashRecoveryCode(codes[i])
+	}
+	hashedJson, err := json.Marshal(hashedCodes)
+	if err != nil {
+		return nil, "", err
+	}
+	return codes, string(hashedJson), nil
+}
+
+// Recovery codes are long random strings, so a plain SHA-256 is enough to keep them unusable if leaked
+func (s *UserService) hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(code)))
+	return hex.EncodeToString(sum[:])
 }
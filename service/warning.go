@@ -0,0 +1,213 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"gorm.io/gorm"
+)
+
+type WarningService struct {
+	SettingService
+}
+
+// Notifiers configured in the settings
+func (s *WarningService) notifiers() []Notifier {
+	var notifiers []Notifier
+	url, err := s.SettingService.GetNotifyUrl()
+	if err == nil && url != "" {
+		notifiers = append(notifiers, &HttpNotifier{Url: url})
+	}
+	smtpSettings, err := s.SettingService.GetSmtp()
+	if err == nil && smtpSettings["smtpHost"] != "" && smtpSettings["smtpTo"] != "" {
+		var to []string
+		for _, addr := range strings.Split(smtpSettings["smtpTo"], ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				to = append(to, addr)
+			}
+		}
+		notifiers = append(notifiers, &SmtpNotifier{
+			Host:     smtpSettings["smtpHost"],
+			Port:     smtpSettings["smtpPort"],
+			Username: smtpSettings["smtpUsername"],
+			Password: smtpSettings["smtpPassword"],
+			From:     smtpSettings["smtpFrom"],
+			To:       to,
+		})
+	}
+	return notifiers
+}
+
+// Crossed thresholds of a client, ordered from the least to the most severe
+func crossedThresholds(client *model.Client, kind string, thresholds []int, now int64) []int {
+	var crossed []int
+	for _, threshold := range thresholds {
+		switch kind {
+		case "volume":
+			if client.Volume > 0 && (client.Up+client.Down)*100 >= client.Volume*int64(threshold) {
+				crossed = append(crossed, threshold)
+			}
+		case "expiry":
+			if client.Expiry > now && client.Expiry-now <= int64(threshold)*86400 {
+				crossed = append(crossed, threshold)
+			}
+		}
+	}
+	// Higher percents and fewer days are more severe
+	if kind == "volume" {
+		sort.Ints(crossed)
+	} else {
+		sort.Sort(sort.Reverse(sort.IntSlice(crossed)))
+	}
+	return crossed
+}
+
+// Record the newly crossed warning thresholds of enabled clients and notify about the most severe one.
+// Each threshold fires once per period, which is the traffic reset for volume and the expiry date for expiry.
+func (s *WarningService) WarnClients() error {
+	volumeThresholds, err := s.SettingService.GetWarnVolume()
+	if err != nil {
+		return err
+	}
+	expiryThresholds, err := s.SettingService.GetWarnExpiry()
+	if err != nil {
+		return err
+	}
+	if len(volumeThresholds) == 0 && len(expiryThresholds) == 0 {
+		return nil
+	}
+
+	db := database.GetDB()
+	now := time.Now().Unix()
+	var clients []model.Client
+	err = db.Model(model.Client{}).Where("enable = true AND (volume > 0 OR expiry > ?)", now).Find(&clients).Error
+	if err != nil {
+		return err
+	}
+
+	var notifications []*Notification
+	var warnings []*model.ClientWarning
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, client := range clients {
+			for _, kind := range []string{"volume", "expiry"} {
+				thresholds := volumeThresholds
+				period := client.LastReset
+				if kind == "expiry" {
+					thresholds = expiryThresholds
+					period = client.Expiry
+				}
+				crossed := crossedThresholds(&client, kind, thresholds, now)
+				if len(crossed) == 0 {
+					continue
+				}
+				var fired []int
+				err = tx.Model(model.ClientWarning{}).
+					Where("client_id = ? AND kind = ? AND period = ?", client.Id, kind, period).
+					Pluck("threshold", &fired).Error
+				if err != nil {
+					return err
+				}
+				var newWarning *model.ClientWarning
+				for _, threshold := range crossed {
+					if containsInt(fired, threshold) {
+						continue
+					}
+					newWarning = &model.ClientWarning{
+						ClientId:  client.Id,
+						Name:      client.Name,
+						Kind:      kind,
+						Threshold: threshold,
+						Period:    period,
+						DateTime:  now,
+					}
+					err = tx.Create(newWarning).Error
+					if err != nil {
+						return err
+					}
+				}
+				if newWarning == nil {
+					continue
+				}
+				// Only the most severe new threshold is announced
+				notification := &Notification{
+					Event:     "clients.warning",
+					Client:    client.Name,
+					ClientId:  client.Id,
+					Kind:      kind,
+					Threshold: newWarning.Threshold,
+					Used:      client.Up + client.Down,
+					Volume:    client.Volume,
+					Expiry:    client.Expiry,
+					DateTime:  now,
+				}
+				obj, _ := json.Marshal(notification)
+				change := model.Changes{
+					DateTime: now,
+					Actor:    "WarnJob",
+					Key:      "clients",
+					Action:   "warning",
+					Obj:      obj,
+					ObjId:    strconv.FormatUint(uint64(client.Id), 10),
+				}
+				err = tx.Create(&change).Error
+				if err != nil {
+					return err
+				}
+				err = enqueueWebhooks(tx, change)
+				if err != nil {
+					return err
+				}
+				notifications = append(notifications, notification)
+				warnings = append(warnings, newWarning)
+			}
+		}
+		return nil
+	})
+	if err != nil || len(notifications) == 0 {
+		return err
+	}
+	LastUpdate = now
+
+	notifiers := s.notifiers()
+	for i, notification := range notifications {
+		var errs []string
+		for _, notifier := range notifiers {
+			if notifyErr := notifier.Notify(notification); notifyErr != nil {
+				logger.Warning(notifier.Name(), " notification for ", notification.Client, " failed: ", notifyErr)
+				errs = append(errs, notifier.Name()+": "+notifyErr.Error())
+			}
+		}
+		db.Model(model.ClientWarning{}).Where("id = ?", warnings[i].Id).Updates(map[string]interface{}{
+			"notified": len(notifiers) > 0 && len(errs) < len(notifiers),
+			"error":    strings.Join(errs, "; "),
+		})
+	}
+	return nil
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *WarningService) GetWarnings(clientId string, limit int) ([]model.ClientWarning, error) {
+	db := database.GetDB()
+	var warnings []model.ClientWarning
+	query := db.Model(model.ClientWarning{})
+	if clientId != "" {
+		query = query.Where("client_id = ?", clientId)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&warnings).Error
+	return warnings, err
+}
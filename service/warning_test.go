@@ -0,0 +1,31 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func TestCrossedThresholds(t *testing.T) {
+	now := int64(1700000000)
+	cases := []struct {
+		client     model.Client
+		kind       string
+		thresholds []int
+		want       []int
+	}{
+		{model.Client{Volume: 1000, Up: 300, Down: 500}, "volume", []int{95, 80}, []int{80}},
+		{model.Client{Volume: 1000, Up: 450, Down: 500}, "volume", []int{95, 80}, []int{80, 95}},
+		{model.Client{Volume: 0, Up: 450, Down: 500}, "volume", []int{80}, nil},
+		{model.Client{Expiry: now + 2*86400}, "expiry", []int{1, 3}, []int{3}},
+		{model.Client{Expiry: now + 3600}, "expiry", []int{1, 3}, []int{3, 1}},
+		{model.Client{Expiry: now - 3600}, "expiry", []int{1, 3}, nil},
+	}
+	for i, c := range cases {
+		got := crossedThresholds(&c.client, c.kind, c.thresholds, now)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("case %d: expected %v, got %v", i, c.want, got)
+		}
+	}
+}
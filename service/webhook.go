@@ -0,0 +1,312 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// Delivery states
+const (
+	DeliveryPending = "pending"
+	DeliverySuccess = "success"
+	DeliveryFailed  = "failed"
+)
+
+const (
+	webhookMaxAttempts = 8
+	webhookBaseDelay   = 30 * time.Second
+	webhookMaxDelay    = time.Hour
+	webhookTimeout     = 10 * time.Second
+	webhookBatch       = 50
+	webhookLogAge      = 7 * 24 * time.Hour
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// Held while deliveries are sent, so overlapping runs do not send the same ones twice
+var deliverAccess sync.Mutex
+
+type WebhookService struct {
+}
+
+// Event name of a change, e.g. "clients.new", "clients.disable" or "core.restart"
+func webhookEvent(change *model.Changes) string {
+	return change.Key + "." + change.Action
+}
+
+// An empty event list or "*" subscribes to every event. "<key>.*" subscribes to every action of a key.
+func webhookWants(hook *model.Webhook, event string) bool {
+	var events []string
+	json.Unmarshal(hook.Events, &events)
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == "*" || e == event {
+			return true
+		}
+		if strings.HasSuffix(e, ".*") && strings.HasPrefix(event, strings.TrimSuffix(e, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Queue deliveries for the given changes inside the same transaction,
+// so nothing is sent for changes which are rolled back
+func enqueueWebhooks(tx *gorm.DB, changes ...model.Changes) error {
+	var hooks []model.Webhook
+	err := tx.Model(model.Webhook{}).Where("enabled = ?", true).Find(&hooks).Error
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	var deliveries []model.WebhookDelivery
+	for _, change := range changes {
+		event := webhookEvent(&change)
+		payload, err := json.Marshal(map[string]interface{}{
+			"event":    event,
+			"actor":    change.Actor,
+			"dateTime": change.DateTime,
+			"objId":    change.ObjId,
+			"data":     change.Obj,
+			"diff":     change.Diff,
+		})
+		if err != nil {
+			return err
+		}
+		for _, hook := range hooks {
+			if !webhookWants(&hook, event) {
+				continue
+			}
+			deliveries = append(deliveries, model.WebhookDelivery{
+				WebhookId:   hook.Id,
+				Event:       event,
+				Payload:     payload,
+				Status:      DeliveryPending,
+				NextAttempt: change.DateTime,
+				DateTime:    change.DateTime,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Create(&deliveries).Error
+}
+
+func (s *WebhookService) GetWebhooks() ([]model.Webhook, error) {
+	db := database.GetDB()
+	var hooks []model.Webhook
+	err := db.Model(model.Webhook{}).Find(&hooks).Error
+	return hooks, err
+}
+
+func validateWebhookUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return common.NewError("invalid webhook url: ", rawUrl)
+	}
+	return nil
+}
+
+// The secret is generated here and returned once, it signs every payload
+func (s *WebhookService) AddWebhook(rawUrl string, events []string, desc string) (string, error) {
+	err := validateWebhookUrl(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	eventsJson, err := json.Marshal(events)
+	if err != nil {
+		return "", err
+	}
+	secret := common.Random(32)
+	db := database.GetDB()
+	err = db.Create(&model.Webhook{
+		Desc:    desc,
+		Url:     rawUrl,
+		Secret:  secret,
+		Events:  eventsJson,
+		Enabled: true,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (s *WebhookService) UpdateWebhook(id string, rawUrl string, events []string, desc string, enabled bool) error {
+	err := validateWebhookUrl(rawUrl)
+	if err != nil {
+		return err
+	}
+	eventsJson, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Model(model.Webhook{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"url":     rawUrl,
+		"events":  eventsJson,
+		"desc":    desc,
+		"enabled": enabled,
+	}).Error
+}
+
+func (s *WebhookService) DeleteWebhook(id string) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("webhook_id = ?", id).Delete(model.WebhookDelivery{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(model.Webhook{}).Error
+	})
+}
+
+func (s *WebhookService) GetDeliveries(webhookId string, limit int) ([]model.WebhookDelivery, error) {
+	db := database.GetDB()
+	var deliveries []model.WebhookDelivery
+	query := db.Model(model.WebhookDelivery{})
+	if webhookId != "" {
+		query = query.Where("webhook_id = ?", webhookId)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// Queue a finished delivery again with a fresh set of attempts
+func (s *WebhookService) Redeliver(id string) error {
+	db := database.GetDB()
+	result := db.Model(model.WebhookDelivery{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       DeliveryPending,
+		"attempts":     0,
+		"next_attempt": time.Now().Unix(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("delivery not found")
+	}
+	return nil
+}
+
+// Send due deliveries and reschedule failed ones with exponential backoff
+func (s *WebhookService) DeliverPending() error {
+	if !deliverAccess.TryLock() {
+		logger.Debug("webhook deliveries of the last run are still being sent")
+		return nil
+	}
+	defer deliverAccess.Unlock()
+
+	db := database.GetDB()
+	now := time.Now()
+
+	err := db.Where("status != ? AND date_time < ?", DeliveryPending, now.Add(-webhookLogAge).Unix()).Delete(model.WebhookDelivery{}).Error
+	if err != nil {
+		return err
+	}
+
+	var deliveries []model.WebhookDelivery
+	err = db.Model(model.WebhookDelivery{}).
+		Where("status = ? AND next_attempt <= ?", DeliveryPending, now.Unix()).
+		Order("id").Limit(webhookBatch).Find(&deliveries).Error
+	if err != nil || len(deliveries) == 0 {
+		return err
+	}
+	hooks := map[uint]*model.Webhook{}
+	for _, delivery := range deliveries {
+		hook, ok := hooks[delivery.WebhookId]
+		if !ok {
+			hook = &model.Webhook{}
+			err = db.Model(model.Webhook{}).Where("id = ?", delivery.WebhookId).First(hook).Error
+			if err != nil {
+				logger.Warning("webhook of delivery ", delivery.Id, " not found: ", err)
+				hook = nil
+			}
+			hooks[delivery.WebhookId] = hook
+		}
+
+		delivery.Attempts++
+		if hook == nil || !hook.Enabled {
+			delivery.Status = DeliveryFailed
+			delivery.LastError = "webhook deleted or disabled"
+		} else {
+			delivery.ResponseCode, err = s.send(hook, &delivery)
+			if err == nil {
+				delivery.Status = DeliverySuccess
+				delivery.LastError = ""
+			} else if delivery.Attempts >= webhookMaxAttempts {
+				delivery.Status = DeliveryFailed
+				delivery.LastError = err.Error()
+			} else {
+				delay := webhookBaseDelay * time.Duration(math.Pow(2, float64(delivery.Attempts-1)))
+				if delay > webhookMaxDelay {
+					delay = webhookMaxDelay
+				}
+				delivery.NextAttempt = now.Add(delay).Unix()
+				delivery.LastError = err.Error()
+			}
+		}
+		err = db.Save(&delivery).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Signature is the hex HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookService) send(hook *model.Webhook, delivery *model.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", config.GetName()+"/"+config.GetVersion())
+	req.Header.Set("X-SUI-Event", delivery.Event)
+	req.Header.Set("X-SUI-Delivery", strconv.FormatUint(delivery.Id, 10))
+	req.Header.Set("X-SUI-Timestamp", timestamp)
+	req.Header.Set("X-SUI-Signature", signWebhook(hook.Secret, timestamp, delivery.Payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, common.NewError("unexpected status: ", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
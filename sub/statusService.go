@@ -0,0 +1,207 @@
+package sub
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/util"
+	"github.com/alireza0/s-ui/util/qr"
+)
+
+// Days of usage shown in the chart of the status page
+const statusDays = 30
+
+type StatusLink struct {
+	Remark string
+	Uri    string
+	QR     template.HTML
+}
+
+type StatusDay struct {
+	Label  string
+	Up     int64
+	Down   int64
+	Height int // percent of the busiest day
+	Title  string
+}
+
+type StatusApp struct {
+	Name string
+	Url  template.URL
+}
+
+type ClientStatus struct {
+	Name      string
+	Enable    bool
+	Reason    string
+	Up        string
+	Down      string
+	Used      string
+	Volume    string
+	Remaining string
+	UsedPct   int
+	Expiry    string
+	DaysLeft  int64
+	Pending   bool // expiry starts with the first connection
+	SubUrl    string
+	SubQR     template.HTML
+	Links     []StatusLink
+	Days      []StatusDay
+	From      string
+	To        string
+	Apps      []StatusApp
+}
+
+type StatusService struct {
+	service.SettingService
+	SubService
+}
+
+func (s *StatusService) GetStatus(subId string, host string) (*ClientStatus, error) {
+	client, err := findClient(subId, false)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := s.SettingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+
+	used := client.Up + client.Down
+	status := &ClientStatus{
+		Name:   client.Name,
+		Enable: client.Enable,
+		Reason: client.DisabledReason,
+		Up:     s.SubService.formatTraffic(client.Up),
+		Down:   s.SubService.formatTraffic(client.Down),
+		Used:   s.SubService.formatTraffic(used),
+	}
+	if client.Volume > 0 {
+		status.Volume = s.SubService.formatTraffic(client.Volume)
+		status.Remaining = s.SubService.formatTraffic(max(client.Volume-used, 0))
+		status.UsedPct = int(min(used*100/client.Volume, 100))
+	}
+	if client.ExpiryAfterUse > 0 {
+		status.Pending = true
+		status.DaysLeft = client.ExpiryAfterUse / 86400
+	} else if expiry := util.ClientExpiry(client); expiry > 0 {
+		status.Expiry = time.Unix(expiry, 0).In(loc).Format("2006-01-02 15:04")
+		status.DaysLeft = max(expiry-time.Now().Unix(), 0) / 86400
+	}
+
+	// Knowing the name of a client does not reveal its token
+	if subId == client.SubToken || subId == client.PrevSubToken {
+		subURI, err := s.SettingService.GetFinalSubURI(host)
+		if err != nil {
+			return nil, err
+		}
+		status.SubUrl = subURI + url.PathEscape(client.SubToken)
+		status.SubQR = qrSVG(status.SubUrl)
+		status.Apps = importApps(status.SubUrl, client.Name)
+	}
+
+	var links []Link
+	json.Unmarshal(client.Links, &links)
+	for _, link := range links {
+		if link.Type == "sub" {
+			continue
+		}
+		status.Links = append(status.Links, StatusLink{
+			Remark: link.Remark,
+			Uri:    link.Uri,
+			QR:     qrSVG(link.Uri),
+		})
+	}
+
+	status.Days, err = s.dailyUsage(client.Name, loc)
+	if err != nil {
+		return nil, err
+	}
+	status.From = status.Days[0].Label
+	status.To = status.Days[len(status.Days)-1].Label
+	return status, nil
+}
+
+func qrSVG(text string) template.HTML {
+	svg, err := qr.SVG(text)
+	if err != nil {
+		logger.Warning("sub: unable to render QR code: ", err)
+		return ""
+	}
+	// Generated markup only, no user input is copied into it
+	return template.HTML(svg)
+}
+
+// One-click import links of common apps for the subscription
+func importApps(subUrl string, name string) []StatusApp {
+	escaped := url.QueryEscape(subUrl)
+	escapedName := url.QueryEscape(name)
+	withFormat := func(format string) string {
+		if strings.Contains(subUrl, "?") {
+			return url.QueryEscape(subUrl + "&format=" + format)
+		}
+		return url.QueryEscape(subUrl + "?format=" + format)
+	}
+	return []StatusApp{
+		{"sing-box", template.URL("sing-box://import-remote-profile?url=" + withFormat("json") + "#" + escapedName)},
+		{"Hiddify", template.URL("hiddify://import/" + subUrl + "#" + escapedName)},
+		{"v2rayNG", template.URL("v2rayng://install-sub?url=" + escaped + "&name=" + escapedName)},
+		{"Streisand", template.URL("streisand://import/" + subUrl + "#" + escapedName)},
+		{"Clash Meta", template.URL("clash://install-config?url=" + withFormat("clash") + "&name=" + escapedName)},
+	}
+}
+
+// Upload and download of the client per day in the panel's time location
+func (s *StatusService) dailyUsage(name string, loc *time.Location) ([]StatusDay, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from := today.AddDate(0, 0, 1-statusDays)
+	_, offset := now.Zone()
+
+	var rows []struct {
+		Day       int64
+		Direction bool
+		Traffic   int64
+	}
+	db := database.GetDB()
+	err := db.Model(model.Stats{}).
+		Select("(date_time + ?) / 86400 as day, direction, sum(traffic) as traffic", offset).
+		Where("resource = 'user' AND tag = ? AND date_time >= ?", name, from.Unix()).
+		Group("day, direction").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	first := (from.Unix() + int64(offset)) / 86400
+	days := make([]StatusDay, statusDays)
+	var busiest int64
+	for i := range days {
+		days[i].Label = from.AddDate(0, 0, i).Format("01-02")
+	}
+	for _, row := range rows {
+		i := row.Day - first
+		if i < 0 || i >= statusDays {
+			continue
+		}
+		if row.Direction {
+			days[i].Up += row.Traffic
+		} else {
+			days[i].Down += row.Traffic
+		}
+		busiest = max(busiest, days[i].Up+days[i].Down)
+	}
+	for i := range days {
+		if busiest > 0 {
+			days[i].Height = int((days[i].Up + days[i].Down) * 100 / busiest)
+		}
+		days[i].Title = days[i].Label + ": ↑" + s.SubService.formatTraffic(days[i].Up) + " ↓" + s.SubService.formatTraffic(days[i].Down)
+	}
+	return days, nil
+}
@@ -1,17 +1,28 @@
 package sub
 
 import (
+	_ "embed"
+	"html/template"
+	"net"
+	"strings"
+
 	"github.com/alireza0/s-ui/logger"
 	"github.com/alireza0/s-ui/service"
 
 	"github.com/gin-gonic/gin"
 )
 
+//go:embed status.html
+var statusPage string
+
+var statusTemplate = template.Must(template.New("status").Parse(statusPage))
+
 type SubHandler struct {
 	service.SettingService
 	SubService
 	JsonService
 	ClashService
+	StatusService
 }
 
 func NewSubHandler(g *gin.RouterGroup) {
@@ -30,6 +41,10 @@ func (s *SubHandler) subs(c *gin.Context) {
 	var err error
 	subId := c.Param("subid")
 	format, isFormat := c.GetQuery("format")
+	if !isFormat && isBrowser(c) {
+		s.statusPage(c, subId)
+		return
+	}
 	if isFormat {
 		switch format {
 		case "json":
@@ -76,3 +91,27 @@ func (s *SubHandler) addHeaders(c *gin.Context, headers []string) {
 	c.Writer.Header().Set("Profile-Update-Interval", headers[1])
 	c.Writer.Header().Set("Profile-Title", headers[2])
 }
+
+// Browsers ask for HTML, subscription clients do not
+func isBrowser(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/html")
+}
+
+func (s *SubHandler) statusPage(c *gin.Context, subId string) {
+	host, _, err := net.SplitHostPort(c.Request.Host)
+	if err != nil {
+		host = c.Request.Host
+	}
+	status, err := s.StatusService.GetStatus(subId, host)
+	if err != nil {
+		logger.Error(err)
+		c.String(404, "Not found!")
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	err = statusTemplate.Execute(c.Writer, status)
+	if err != nil {
+		logger.Error(err)
+	}
+}
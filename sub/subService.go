@@ -10,6 +10,7 @@ import (
 	"github.com/alireza0/s-ui/database/model"
 	"github.com/alireza0/s-ui/service"
 	"github.com/alireza0/s-ui/util"
+	"github.com/alireza0/s-ui/util/common"
 )
 
 type SubService struct {
@@ -45,9 +46,31 @@ func (s *SubService) GetSubs(subId string) (*string, []string, error) {
 }
 
 func (j *SubService) getClientBySubId(subId string) (*model.Client, error) {
+	return findClient(subId, true)
+}
+
+// Find a client by its subscription token, by its previous token during the grace period
+// or by its name if name lookups are enabled
+func findClient(subId string, onlyEnabled bool) (*model.Client, error) {
+	if subId == "" {
+		return nil, common.NewError("empty subscription id")
+	}
+	condition := "(sub_token = ? OR (prev_sub_token = ? AND prev_sub_token_expiry > ?)"
+	args := []interface{}{subId, subId, time.Now().Unix()}
+	settingService := service.SettingService{}
+	if nameLookup, _ := settingService.GetSubNameLookup(); nameLookup {
+		condition += " OR name = ?"
+		args = append(args, subId)
+	}
+	condition += ")"
+
 	db := database.GetDB()
+	query := db.Model(model.Client{}).Where(condition, args...)
+	if onlyEnabled {
+		query = query.Where("enable = true")
+	}
 	client := &model.Client{}
-	err := db.Model(model.Client{}).Where("enable = true and name = ?", subId).First(client).Error
+	err := query.First(client).Error
 	if err != nil {
 		return nil, err
 	}
@@ -66,8 +89,8 @@ func (s *SubService) getClientInfo(c *model.Client) string {
 	if vol := c.Volume - (c.Up + c.Down); vol > 0 {
 		result = append(result, fmt.Sprintf("%s%s", s.formatTraffic(vol), "📊"))
 	}
-	if c.Expiry > 0 {
-		result = append(result, fmt.Sprintf("%d%s⏳", (c.Expiry-now)/86400, "Days"))
+	if expiry := util.ClientExpiry(c); expiry > 0 {
+		result = append(result, fmt.Sprintf("%d%s⏳", (expiry-now)/86400, "Days"))
 	}
 	if len(result) > 0 {
 		return " " + strings.Join(result, " ")
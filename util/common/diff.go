@@ -0,0 +1,60 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+type DiffEntry struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// Compare two JSON documents field by field. Nested objects are walked with dotted paths,
+// other values including arrays are compared as a whole.
+func JsonDiff(before json.RawMessage, after json.RawMessage) ([]DiffEntry, error) {
+	var oldValue, newValue interface{}
+	if len(before) > 0 {
+		if err := json.Unmarshal(before, &oldValue); err != nil {
+			return nil, err
+		}
+	}
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &newValue); err != nil {
+			return nil, err
+		}
+	}
+	diff := []DiffEntry{}
+	diffValues("", oldValue, newValue, &diff)
+	return diff, nil
+}
+
+func diffValues(path string, oldValue interface{}, newValue interface{}, diff *[]DiffEntry) {
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for key := range oldMap {
+			keys = append(keys, key)
+		}
+		for key := range newMap {
+			if _, ok := oldMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			diffValues(childPath, oldMap[key], newMap[key], diff)
+		}
+		return
+	}
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*diff = append(*diff, DiffEntry{Path: path, Old: oldValue, New: newValue})
+	}
+}
@@ -0,0 +1,37 @@
+package common
+
+import "testing"
+
+func TestJsonDiff(t *testing.T) {
+	before := []byte(`{"tag":"in","listen_port":443,"tls":{"enabled":true},"users":["a"]}`)
+	after := []byte(`{"tag":"in","listen_port":8443,"tls":{"enabled":false},"users":["a","b"],"sniff":true}`)
+	diff, err := JsonDiff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{"listen_port", "sniff", "tls.enabled", "users"}
+	if len(diff) != len(paths) {
+		t.Fatalf("expected %d entries, got %v", len(paths), diff)
+	}
+	for i, path := range paths {
+		if diff[i].Path != path {
+			t.Errorf("entry %d: expected path %s, got %s", i, path, diff[i].Path)
+		}
+	}
+	if diff[0].Old != float64(443) || diff[0].New != float64(8443) {
+		t.Errorf("unexpected port change: %v", diff[0])
+	}
+	if diff[1].Old != nil || diff[1].New != true {
+		t.Errorf("unexpected added field: %v", diff[1])
+	}
+}
+
+func TestJsonDiffDeleted(t *testing.T) {
+	diff, err := JsonDiff([]byte(`{"name":"c1"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff[0].Path != "" || diff[0].New != nil {
+		t.Errorf("expected whole object removal, got %v", diff)
+	}
+}
@@ -7,14 +7,27 @@ import (
 	"github.com/alireza0/s-ui/logger"
 )
 
+// Error of the panel itself, such as invalid input, as opposed to database or system errors
+type Error struct {
+	msg string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
 func NewErrorf(format string, a ...interface{}) error {
-	msg := fmt.Sprintf(format, a...)
-	return errors.New(msg)
+	return &Error{msg: fmt.Sprintf(format, a...)}
 }
 
 func NewError(a ...interface{}) error {
-	msg := fmt.Sprintln(a...)
-	return errors.New(msg)
+	return &Error{msg: fmt.Sprintln(a...)}
+}
+
+// IsError reports whether err was made by NewError or NewErrorf
+func IsError(err error) bool {
+	var e *Error
+	return errors.As(err, &e)
 }
 
 func Recover(msg string) interface{} {
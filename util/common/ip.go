@@ -0,0 +1,36 @@
+package common
+
+import (
+	"net"
+	"strings"
+)
+
+func IsValidIPOrCIDR(value string) bool {
+	if strings.Contains(value, "/") {
+		_, _, err := net.ParseCIDR(value)
+		return err == nil
+	}
+	return net.ParseIP(value) != nil
+}
+
+// Check an address against a list of IPs and CIDRs. An empty list allows every address.
+func IPAllowed(ip string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return false
+	}
+	for _, value := range allowed {
+		if strings.Contains(value, "/") {
+			_, network, err := net.ParseCIDR(value)
+			if err == nil && network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(value); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Check whether the stored value is a bcrypt hash or a legacy plaintext password
+func IsPasswordHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+// Verify a password against the stored value in constant time.
+// Legacy plaintext values are still accepted so that they can be rehashed on login.
+func CheckPassword(stored string, password string) bool {
+	if IsPasswordHashed(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// Report whether a valid stored value should be replaced by a fresh hash
+func NeedsRehash(stored string) bool {
+	if !IsPasswordHashed(stored) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return err != nil || cost != bcrypt.DefaultCost
+}
+
+// API tokens are long random strings, so a plain sha256 is enough to store them
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsTokenHashed(stored string) bool {
+	if len(stored) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(stored)
+	return err == nil
+}
@@ -0,0 +1,34 @@
+package common
+
+import "testing"
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordHashed(hash) {
+		t.Errorf("expected bcrypt hash, got %s", hash)
+	}
+	if !CheckPassword(hash, "secret") {
+		t.Error("expected password to match its hash")
+	}
+	if CheckPassword(hash, "wrong") {
+		t.Error("expected wrong password to be rejected")
+	}
+	if NeedsRehash(hash) {
+		t.Error("fresh hash should not need rehash")
+	}
+}
+
+func TestCheckLegacyPassword(t *testing.T) {
+	if !CheckPassword("admin", "admin") {
+		t.Error("expected legacy plaintext password to match")
+	}
+	if CheckPassword("admin", "admin1") {
+		t.Error("expected wrong legacy password to be rejected")
+	}
+	if !NeedsRehash("admin") {
+		t.Error("legacy plaintext password should need rehash")
+	}
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	crand "crypto/rand"
+	"encoding/base64"
 	"math/big"
 	mrand "math/rand"
 	"sync"
@@ -53,3 +54,12 @@ func RandomInt(n int) int {
 	}
 	return int(result.Int64())
 }
+
+// Unguessable token of 32 URL safe characters for subscription paths
+func NewSubToken() string {
+	b := make([]byte, 24)
+	if _, err := crand.Read(b); err != nil {
+		return Random(32)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 appendix B (SHA1, truncated to 6 digits)
+func TestTotpCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TotpCode(secret, TotpStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("time %d: expected %s, got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := TotpCode(secret, TotpStep(now))
+
+	step, ok := ValidateTotp(secret, code, 0, now)
+	if !ok {
+		t.Fatal("expected current code to be valid")
+	}
+	if _, ok := ValidateTotp(secret, code, step, now); ok {
+		t.Error("expected a used code to be rejected")
+	}
+	if _, ok := ValidateTotp(secret, "000000x", 0, now); ok {
+		t.Error("expected malformed code to be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package qr
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// HELLO WORLD at version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	got := rsRemainder(data, rsDivisor(len(want)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	want := []int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0}
+	for mask, w := range want {
+		if got := formatBits(mask); got != w {
+			t.Errorf("mask %d: expected %015b, got %015b", mask, w, got)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	if got := dataCodewords(1); got != 16 {
+		t.Errorf("version 1: expected 16 data codewords, got %d", got)
+	}
+	if got := dataCodewords(10); got != 216 {
+		t.Errorf("version 10: expected 216 data codewords, got %d", got)
+	}
+	if got := dataCodewords(40); got != 2334 {
+		t.Errorf("version 40: expected 2334 data codewords, got %d", got)
+	}
+	if got := alignmentPositions(7); !reflect.DeepEqual(got, []int{6, 22, 38}) {
+		t.Errorf("version 7: unexpected alignment positions %v", got)
+	}
+	if got := alignmentPositions(32); !reflect.DeepEqual(got, []int{6, 34, 60, 86, 112, 138}) {
+		t.Errorf("version 32: unexpected alignment positions %v", got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	q, err := Encode("vless://2f5c1d7e-1111-2222-3333-444455556666@example.com:443?security=reality&type=tcp#client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Size != 41 {
+		t.Errorf("expected version 6, got size %d", q.Size)
+	}
+	if !q.Dark(0, 0) || q.Dark(1, 1) || !q.Dark(8, q.Size-8) {
+		t.Error("finder pattern or dark module misplaced")
+	}
+}
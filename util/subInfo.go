@@ -2,13 +2,23 @@ package util
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/alireza0/s-ui/database/model"
 )
 
+// Expiry of the client as seen now. A client which expires after first use and is not used yet
+// would expire the given duration from now.
+func ClientExpiry(client *model.Client) int64 {
+	if client.ExpiryAfterUse > 0 {
+		return time.Now().Unix() + client.ExpiryAfterUse
+	}
+	return client.Expiry
+}
+
 func GetHeaders(client *model.Client, updateInterval int) []string {
 	var headers []string
-	headers = append(headers, fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", client.Up, client.Down, client.Volume, client.Expiry))
+	headers = append(headers, fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", client.Up, client.Down, client.Volume, ClientExpiry(client)))
 	headers = append(headers, fmt.Sprintf("%d", updateInterval))
 	headers = append(headers, client.Name)
 	return headers
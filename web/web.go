@@ -109,6 +109,12 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	group_api := engine.Group(base_url + "api")
 	api.NewAPIHandler(group_api, apiv2)
 
+	group_rest := engine.Group(base_url + "api/v1")
+	api.NewRESTHandler(group_rest, apiv2)
+
+	group_metrics := engine.Group(base_url + "metrics")
+	api.NewMetricsHandler(group_metrics, apiv2)
+
 	// Serve index.html as the entry point
 	// Handle all other routes by serving index.html
 	engine.NoRoute(func(c *gin.Context) {